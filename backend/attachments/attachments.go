@@ -0,0 +1,206 @@
+package attachments
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"social-net/db"
+	"social-net/imaging"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	TargetPost      = "post"
+	TargetGroupPost = "group_post"
+	// TargetPostRevision holds the attachments a post had before an edit.
+	TargetPostRevision = "post_revision"
+
+	MaxPerPost  = 4
+	MaxFileSize = 2 * 1024 * 1024
+)
+
+type Attachment struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	AltText  string `json:"alt_text"`
+	Position int    `json:"position"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	// Smaller copies for feeds and previews; they are the original for
+	// images uploaded before sizes were stored.
+	MediumURL string `json:"medium_url"`
+	ThumbURL  string `json:"thumb_url"`
+}
+
+type Upload struct {
+	Filename string
+	AltText  string
+	Width    int
+	Height   int
+}
+
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// SaveUploads processes every file sent as "images" (or the older single
+// "image" field) before writing any of them to ./uploads, so a bad file in
+// the batch leaves nothing behind. Each image is re-encoded without its
+// metadata and stored in every size. Alt texts are matched to files by index.
+// Callers Discard the uploads if the content they belong to isn't saved.
+func SaveUploads(w http.ResponseWriter, r *http.Request, prefix string) ([]Upload, bool) {
+	if r.MultipartForm == nil {
+		return nil, true
+	}
+	files := append(r.MultipartForm.File["images"], r.MultipartForm.File["image"]...)
+	if len(files) == 0 {
+		return nil, true
+	}
+	if len(files) > MaxPerPost {
+		http.Error(w, fmt.Sprintf("A post can have at most %d images", MaxPerPost), http.StatusBadRequest)
+		return nil, false
+	}
+	altTexts := r.MultipartForm.Value["alt_text"]
+
+	type processed struct {
+		ext   string
+		sizes []imaging.Encoded
+	}
+	images := make([]processed, len(files))
+	uploads := make([]Upload, len(files))
+	for i, handler := range files {
+		if handler.Size > MaxFileSize {
+			http.Error(w, "image file too large", http.StatusBadRequest)
+			return nil, false
+		}
+		data, ok := read(handler)
+		if !ok {
+			http.Error(w, "Invalid image file type", http.StatusBadRequest)
+			return nil, false
+		}
+		ext, sizes, err := imaging.Process(data)
+		if err != nil {
+			if err == imaging.ErrInvalid || err == imaging.ErrTooLarge {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return nil, false
+			}
+			log.Println("Failed to process image:", err)
+			http.Error(w, "Failed to save image", http.StatusInternalServerError)
+			return nil, false
+		}
+		images[i] = processed{ext, sizes}
+		if i < len(altTexts) {
+			uploads[i].AltText = altTexts[i]
+		}
+	}
+
+	for i, image := range images {
+		fileID, err := uuid.NewV7()
+		if err != nil {
+			Discard(uploads[:i])
+			http.Error(w, "Failed to save image", http.StatusInternalServerError)
+			return nil, false
+		}
+		stored, err := imaging.Write(fmt.Sprintf("%s_%s", prefix, fileID.String()), image.ext, image.sizes)
+		if err != nil {
+			Discard(uploads[:i])
+			log.Println("Failed to save image:", err)
+			http.Error(w, "Failed to save image", http.StatusInternalServerError)
+			return nil, false
+		}
+		uploads[i].Filename, uploads[i].Width, uploads[i].Height = stored.Filename, stored.Width, stored.Height
+	}
+	return uploads, true
+}
+
+// Discard removes uploads that were written but never attached.
+func Discard(uploads []Upload) {
+	for _, upload := range uploads {
+		imaging.Remove(upload.Filename)
+	}
+}
+
+// read returns the content of an uploaded file if it looks like an image.
+func read(handler *multipart.FileHeader) ([]byte, bool) {
+	file, err := handler.Open()
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil || !allowedTypes[http.DetectContentType(data)] {
+		return nil, false
+	}
+	return data, true
+}
+
+func Insert(tx *sql.Tx, targetType, targetID string, uploads []Upload) error {
+	for i, upload := range uploads {
+		attachmentID, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO attachments (id, target_type, target_id, filename, alt_text, position, width, height, creation_date)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, attachmentID.String(), targetType, targetID, upload.Filename, upload.AltText, i, upload.Width, upload.Height, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy duplicates the attachment rows of one target onto another; the files
+// are shared.
+func Copy(tx *sql.Tx, fromType, fromID, toType, toID string) error {
+	_, err := tx.Exec(`
+		INSERT INTO attachments (id, target_type, target_id, filename, alt_text, position, width, height, creation_date)
+		SELECT ? || '_' || position, ?, ?, filename, alt_text, position, width, height, creation_date
+		FROM attachments
+		WHERE target_type = ? AND target_id = ?
+	`, toID, toType, toID, fromType, fromID)
+	return err
+}
+
+func Delete(tx *sql.Tx, targetType, targetID string) error {
+	_, err := tx.Exec("DELETE FROM attachments WHERE target_type = ? AND target_id = ?", targetType, targetID)
+	return err
+}
+
+func List(targetType, targetID string) ([]Attachment, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, filename, COALESCE(alt_text, ''), position, width, height
+		FROM attachments
+		WHERE target_type = ? AND target_id = ?
+		ORDER BY position ASC
+	`, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Attachment{}
+	for rows.Next() {
+		var attachment Attachment
+		var filename string
+		err := rows.Scan(&attachment.ID, &filename, &attachment.AltText, &attachment.Position, &attachment.Width, &attachment.Height)
+		if err != nil {
+			return nil, err
+		}
+		attachment.URL = imaging.BaseURL + filename
+		attachment.MediumURL = imaging.URL(filename, imaging.SizeMedium)
+		attachment.ThumbURL = imaging.URL(filename, imaging.SizeThumb)
+		list = append(list, attachment)
+	}
+	return list, rows.Err()
+}
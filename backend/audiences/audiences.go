@@ -0,0 +1,515 @@
+package audiences
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/session"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	MaxNameLength = 50
+	MaxLists      = 20
+)
+
+type List struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Members   []string  `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Summary struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ListRequest struct {
+	ListID  string `json:"list_id"`
+	Name    string `json:"name"`
+	Members string `json:"members"`
+}
+
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// MemberCondition is the SQL for "user ? is on one of the lists post p was
+// shared with", for feed queries that filter semi-private posts in SQL.
+const MemberCondition = `EXISTS (
+	SELECT 1 FROM post_audience_lists pal
+	JOIN audience_list_members alm ON alm.list_id = pal.list_id
+	WHERE pal.post_id = p.id AND alm.user_id = ?)`
+
+// IsMember reports whether userID is currently on any list postID targets.
+// Membership is looked up at read time, so adding someone to a list grants
+// access to everything already shared with it.
+func IsMember(userID, postID string) (bool, error) {
+	var exists bool
+	err := db.DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM post_audience_lists pal
+			JOIN audience_list_members alm ON alm.list_id = pal.list_id
+			WHERE pal.post_id = ? AND alm.user_id = ?
+		)`, postID, userID).Scan(&exists)
+	return exists, err
+}
+
+// Owned parses a comma-separated list of list IDs and checks that every one
+// of them belongs to ownerID.
+func Owned(ownerID, listIDs string) ([]string, error) {
+	seen := map[string]bool{}
+	var ids []string
+	for _, id := range strings.Split(listIDs, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		var exists bool
+		err := db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM audience_lists WHERE id = ? AND owner_id = ?)", id, ownerID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("audience list %s not found", id)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func Attach(exec Execer, postID string, listIDs []string) error {
+	for _, listID := range listIDs {
+		_, err := exec.Exec("INSERT OR IGNORE INTO post_audience_lists (post_id, list_id) VALUES (?, ?)", postID, listID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Detach(exec Execer, postID string) error {
+	_, err := exec.Exec("DELETE FROM post_audience_lists WHERE post_id = ?", postID)
+	return err
+}
+
+func ForPost(postID string) ([]Summary, error) {
+	rows, err := db.DB.Query(`
+		SELECT l.id, l.name FROM post_audience_lists pal
+		JOIN audience_lists l ON l.id = pal.list_id
+		WHERE pal.post_id = ?
+		ORDER BY l.name
+	`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lists := []Summary{}
+	for rows.Next() {
+		var list Summary
+		if err := rows.Scan(&list.ID, &list.Name); err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	return lists, rows.Err()
+}
+
+func resolveMembers(ownerID, usernames string) ([]string, error) {
+	seen := map[string]bool{}
+	var userIDs []string
+	for _, username := range strings.Split(usernames, ",") {
+		username = strings.TrimSpace(username)
+		if username == "" {
+			continue
+		}
+		userID, err := session.GetUserIDFromUsername(username)
+		if err != nil {
+			return nil, err
+		}
+		if userID == "" {
+			return nil, fmt.Errorf("user %s not found", username)
+		}
+		if userID == ownerID {
+			return nil, errors.New("You cannot add yourself to a list")
+		}
+		if !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs, nil
+}
+
+func validName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("Missing name")
+	}
+	if len([]rune(name)) > MaxNameLength {
+		return "", fmt.Errorf("Name must not exceed %d characters", MaxNameLength)
+	}
+	return name, nil
+}
+
+func authenticate(w http.ResponseWriter, r *http.Request, methods string) (string, bool) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", methods+", OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return "", false
+	}
+	if r.Method != methods {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return "", false
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+func checkOwner(w http.ResponseWriter, userID, listID string) bool {
+	if listID == "" {
+		http.Error(w, "Missing list_id", http.StatusBadRequest)
+		return false
+	}
+	var ownerID string
+	err := db.DB.QueryRow("SELECT owner_id FROM audience_lists WHERE id = ?", listID).Scan(&ownerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "List not found", http.StatusNotFound)
+			return false
+		}
+		logger.LogError("Error fetching audience list", err)
+		http.Error(w, "Error fetching list", http.StatusInternalServerError)
+		return false
+	}
+	if ownerID != userID {
+		http.Error(w, "List not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func addMembers(exec Execer, listID string, userIDs []string) error {
+	for _, userID := range userIDs {
+		_, err := exec.Exec("INSERT OR IGNORE INTO audience_list_members (list_id, user_id, added_at) VALUES (?, ?, ?)",
+			listID, userID, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeMembers(exec Execer, listID string, userIDs []string) error {
+	for _, userID := range userIDs {
+		_, err := exec.Exec("DELETE FROM audience_list_members WHERE list_id = ? AND user_id = ?", listID, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetLists(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+
+	rows, err := db.DB.Query("SELECT id, name, created_at FROM audience_lists WHERE owner_id = ? ORDER BY name", userID)
+	if err != nil {
+		logger.LogError("Error fetching audience lists", err)
+		http.Error(w, "Error fetching lists", http.StatusInternalServerError)
+		return
+	}
+	lists := []List{}
+	for rows.Next() {
+		var list List
+		if err := rows.Scan(&list.ID, &list.Name, &list.CreatedAt); err != nil {
+			rows.Close()
+			logger.LogError("Error scanning audience list", err)
+			http.Error(w, "Error fetching lists", http.StatusInternalServerError)
+			return
+		}
+		lists = append(lists, list)
+	}
+	rows.Close()
+
+	for i := range lists {
+		lists[i].Members, err = members(lists[i].ID)
+		if err != nil {
+			logger.LogError("Error fetching audience list members", err)
+			http.Error(w, "Error fetching lists", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lists)
+}
+
+func members(listID string) ([]string, error) {
+	rows, err := db.DB.Query(`
+		SELECT u.username FROM audience_list_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.list_id = ?
+		ORDER BY u.username
+	`, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	usernames := []string{}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames, rows.Err()
+}
+
+func CreateList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request ListRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name, err := validName(request.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	memberIDs, err := resolveMembers(userID, request.Members)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var count int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM audience_lists WHERE owner_id = ?", userID).Scan(&count); err != nil {
+		logger.LogError("Error counting audience lists", err)
+		http.Error(w, "Error creating list", http.StatusInternalServerError)
+		return
+	}
+	if count >= MaxLists {
+		http.Error(w, fmt.Sprintf("You can have at most %d lists", MaxLists), http.StatusBadRequest)
+		return
+	}
+	var taken bool
+	if err := db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM audience_lists WHERE owner_id = ? AND name = ?)", userID, name).Scan(&taken); err != nil {
+		logger.LogError("Error checking audience list name", err)
+		http.Error(w, "Error creating list", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		http.Error(w, "You already have a list with this name", http.StatusConflict)
+		return
+	}
+
+	listID, err := uuid.NewV7()
+	if err != nil {
+		http.Error(w, "Failed to generate list ID", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO audience_lists (id, owner_id, name, created_at) VALUES (?, ?, ?, ?)",
+		listID.String(), userID, name, time.Now())
+	if err != nil {
+		logger.LogError("Error creating audience list", err)
+		http.Error(w, "Error creating list", http.StatusInternalServerError)
+		return
+	}
+	if err := addMembers(tx, listID.String(), memberIDs); err != nil {
+		logger.LogError("Error adding audience list members", err)
+		http.Error(w, "Error creating list", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "List created successfully", "list_id": listID.String()})
+}
+
+func RenameList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request ListRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !checkOwner(w, userID, request.ListID) {
+		return
+	}
+	name, err := validName(request.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var taken bool
+	err = db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM audience_lists WHERE owner_id = ? AND name = ? AND id != ?)",
+		userID, name, request.ListID).Scan(&taken)
+	if err != nil {
+		logger.LogError("Error checking audience list name", err)
+		http.Error(w, "Error renaming list", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		http.Error(w, "You already have a list with this name", http.StatusConflict)
+		return
+	}
+
+	if _, err := db.DB.Exec("UPDATE audience_lists SET name = ? WHERE id = ?", name, request.ListID); err != nil {
+		logger.LogError("Error renaming audience list", err)
+		http.Error(w, "Error renaming list", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "List renamed successfully"})
+}
+
+// DeleteList also takes the list off every post it was used on; those posts
+// stay visible to their individually chosen users only.
+func DeleteList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodDelete)
+	if !ok {
+		return
+	}
+
+	listID := r.URL.Query().Get("list_id")
+	if !checkOwner(w, userID, listID) {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM post_audience_lists WHERE list_id = ?",
+		"DELETE FROM audience_list_members WHERE list_id = ?",
+		"DELETE FROM audience_lists WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, listID); err != nil {
+			logger.LogError("Error deleting audience list", err)
+			http.Error(w, "Error deleting list", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "List deleted successfully"})
+}
+
+func AddMembers(w http.ResponseWriter, r *http.Request) {
+	editMembers(w, r, addMembers)
+}
+
+func RemoveMembers(w http.ResponseWriter, r *http.Request) {
+	editMembers(w, r, removeMembers)
+}
+
+func editMembers(w http.ResponseWriter, r *http.Request, apply func(Execer, string, []string) error) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request ListRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !checkOwner(w, userID, request.ListID) {
+		return
+	}
+	memberIDs, err := resolveMembers(userID, request.Members)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(memberIDs) == 0 {
+		http.Error(w, "Missing members", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := apply(tx, request.ListID, memberIDs); err != nil {
+		logger.LogError("Error updating audience list members", err)
+		http.Error(w, "Error updating list", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "List updated successfully"})
+}
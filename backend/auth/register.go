@@ -7,14 +7,13 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"social-net/db"
+	"social-net/imaging"
 	"social-net/session"
 
 	"github.com/gofrs/uuid"
@@ -99,33 +98,22 @@ func Register(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		ext := filepath.Ext(handler.Filename)
-		safeFilename := fmt.Sprintf("%s_%d%s", user.Username, time.Now().Unix(), ext)
-
-		path := "./uploads"
-		_, err := os.Stat(path)
-		if os.IsNotExist(err) {
-			os.Mkdir(path, os.ModePerm)
+		// re-encoding drops EXIF data such as the location a photo was taken
+		stored, err := imaging.Store(file, fmt.Sprintf("%s_%d", user.Username, time.Now().Unix()))
+		if err == imaging.ErrInvalid || err == imaging.ErrTooLarge {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		savePath := filepath.Join(path, safeFilename)
-
-		out, err := os.Create(savePath)
 		if err != nil {
 			log.Println("Failed to save avatar:", err)
 			http.Error(w, "Failed to save avatar", http.StatusInternalServerError)
 			return
 		}
-		defer out.Close()
-		_, err = io.Copy(out, file)
-		if err != nil {
-			log.Println("Failed to write avatar:", err)
-			http.Error(w, "Failed to write avatar", http.StatusInternalServerError)
-			return
-		}
-		avatarFilename = safeFilename
+		avatarFilename = stored.Filename
 	}
 	user_id, err := uuid.NewV7()
 	if err != nil {
+		imaging.Remove(avatarFilename)
 		log.Println("Failed to generate UUID:", err)
 		http.Error(w, "Unknown Internal Error, Try again", http.StatusInternalServerError)
 		return
@@ -137,6 +125,7 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		user_id, username, user.Email, newpss, user.FirstName, user.LastName, user.Birthday, user.Bio, privacy, avatarFilename, user.Nickname)
 	if err != nil {
+		imaging.Remove(avatarFilename)
 		log.Println("DB error:", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
@@ -0,0 +1,455 @@
+package bookmarks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/pagination"
+	"social-net/session"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	TargetPost      = "post"
+	TargetGroupPost = "group_post"
+
+	MaxCollectionName = 50
+	MaxCollections    = 50
+)
+
+type Item struct {
+	ID           string      `json:"id"`
+	TargetType   string      `json:"target_type"`
+	TargetID     string      `json:"target_id"`
+	CollectionID string      `json:"collection_id"`
+	CreatedAt    time.Time   `json:"created_at"`
+	Post         interface{} `json:"post"`
+}
+
+type Collection struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Count     int       `json:"count"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BookmarkRequest struct {
+	TargetType   string `json:"target_type"`
+	TargetID     string `json:"target_id"`
+	CollectionID string `json:"collection_id"`
+}
+
+type CollectionRequest struct {
+	CollectionID string `json:"collection_id"`
+	Name         string `json:"name"`
+}
+
+// Loader returns a saved item as the viewer would see it in a feed, or false
+// when it no longer exists or the viewer may not see it any more.
+type Loader func(userID, targetID string) (interface{}, bool)
+
+var loaders = map[string]Loader{}
+
+func SetLoader(targetType string, load Loader) {
+	loaders[targetType] = load
+}
+
+// DeleteTarget removes every bookmark of a deleted post.
+func DeleteTarget(tx *sql.Tx, targetType, targetID string) error {
+	_, err := tx.Exec("DELETE FROM bookmarks WHERE target_type = ? AND target_id = ?", targetType, targetID)
+	return err
+}
+
+func authenticate(w http.ResponseWriter, r *http.Request, method string) (string, bool) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", method+", OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return "", false
+	}
+	if r.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return "", false
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+func checkCollectionOwner(w http.ResponseWriter, userID, collectionID string) bool {
+	var ownerID string
+	err := db.DB.QueryRow("SELECT owner_id FROM bookmark_collections WHERE id = ?", collectionID).Scan(&ownerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Collection not found", http.StatusNotFound)
+			return false
+		}
+		logger.LogError("Error fetching bookmark collection", err)
+		http.Error(w, "Error fetching collection", http.StatusInternalServerError)
+		return false
+	}
+	if ownerID != userID {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// GetBookmarks lists saved items newest first, optionally from one
+// collection. Items that were deleted or became invisible are left out.
+func GetBookmarks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, afterArgs := page.Where("created_at", "id")
+
+	filter := "1=1"
+	args := []interface{}{userID}
+	if collectionID := r.URL.Query().Get("collection_id"); collectionID != "" {
+		if !checkCollectionOwner(w, userID, collectionID) {
+			return
+		}
+		filter = "collection_id = ?"
+		args = append(args, collectionID)
+	}
+	args = append(args, afterArgs...)
+
+	rows, err := db.DB.Query(`
+		SELECT id, target_type, target_id, collection_id, created_at, CAST(created_at AS TEXT)
+		FROM bookmarks
+		WHERE user_id = ? AND `+filter+` AND `+after+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, append(args, page.Fetch())...)
+	if err != nil {
+		logger.LogError("Error fetching bookmarks", err)
+		http.Error(w, "Error fetching bookmarks", http.StatusInternalServerError)
+		return
+	}
+
+	items := []Item{}
+	var scanned int
+	var last pagination.Cursor
+	for rows.Next() {
+		scanned++
+		if scanned > page.Limit {
+			break
+		}
+		var item Item
+		var collectionID sql.NullString
+		if err := rows.Scan(&item.ID, &item.TargetType, &item.TargetID, &collectionID, &item.CreatedAt, &last.Time); err != nil {
+			rows.Close()
+			logger.LogError("Error scanning bookmark", err)
+			http.Error(w, "Error fetching bookmarks", http.StatusInternalServerError)
+			return
+		}
+		last.ID = item.ID
+		item.CollectionID = collectionID.String
+		items = append(items, item)
+	}
+	rows.Close()
+
+	visible := []Item{}
+	for _, item := range items {
+		load, ok := loaders[item.TargetType]
+		if !ok {
+			continue
+		}
+		if item.Post, ok = load(userID, item.TargetID); ok {
+			visible = append(visible, item)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.Response{
+		Items:      visible,
+		NextCursor: page.Next(scanned, last),
+	})
+}
+
+// AddBookmark saves an item. Saving an item that is already saved moves it
+// to the given collection.
+func AddBookmark(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request BookmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	load, ok := loaders[request.TargetType]
+	if !ok {
+		http.Error(w, "Invalid target_type", http.StatusBadRequest)
+		return
+	}
+	if request.TargetID == "" {
+		http.Error(w, "Missing target_id", http.StatusBadRequest)
+		return
+	}
+	if _, ok := load(userID, request.TargetID); !ok {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	var collectionID interface{}
+	if request.CollectionID != "" {
+		if !checkCollectionOwner(w, userID, request.CollectionID) {
+			return
+		}
+		collectionID = request.CollectionID
+	}
+
+	bookmarkID, err := uuid.NewV7()
+	if err != nil {
+		http.Error(w, "Failed to generate bookmark ID", http.StatusInternalServerError)
+		return
+	}
+	_, err = db.DB.Exec(`
+		INSERT INTO bookmarks (id, user_id, collection_id, target_type, target_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, target_type, target_id) DO UPDATE SET collection_id = excluded.collection_id
+	`, bookmarkID.String(), userID, collectionID, request.TargetType, request.TargetID, time.Now())
+	if err != nil {
+		logger.LogError("Error saving bookmark", err)
+		http.Error(w, "Error saving bookmark", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Post saved successfully"})
+}
+
+func RemoveBookmark(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request BookmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	result, err := db.DB.Exec("DELETE FROM bookmarks WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, request.TargetType, request.TargetID)
+	if err != nil {
+		logger.LogError("Error removing bookmark", err)
+		http.Error(w, "Error removing bookmark", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "Bookmark not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Bookmark removed successfully"})
+}
+
+func GetCollections(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT c.id, c.name, c.created_at, COUNT(b.id)
+		FROM bookmark_collections c
+		LEFT JOIN bookmarks b ON b.collection_id = c.id
+		WHERE c.owner_id = ?
+		GROUP BY c.id
+		ORDER BY c.name
+	`, userID)
+	if err != nil {
+		logger.LogError("Error fetching bookmark collections", err)
+		http.Error(w, "Error fetching collections", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	collections := []Collection{}
+	for rows.Next() {
+		var collection Collection
+		if err := rows.Scan(&collection.ID, &collection.Name, &collection.CreatedAt, &collection.Count); err != nil {
+			logger.LogError("Error scanning bookmark collection", err)
+			http.Error(w, "Error fetching collections", http.StatusInternalServerError)
+			return
+		}
+		collections = append(collections, collection)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(collections)
+}
+
+func collectionName(userID, collectionID, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("Missing name")
+	}
+	if len([]rune(name)) > MaxCollectionName {
+		return "", fmt.Errorf("Name must not exceed %d characters", MaxCollectionName)
+	}
+	var taken bool
+	err := db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM bookmark_collections WHERE owner_id = ? AND name = ? AND id != ?)",
+		userID, name, collectionID).Scan(&taken)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", errors.New("You already have a collection with this name")
+	}
+	return name, nil
+}
+
+func CreateCollection(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request CollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name, err := collectionName(userID, "", request.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var count int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM bookmark_collections WHERE owner_id = ?", userID).Scan(&count); err != nil {
+		logger.LogError("Error counting bookmark collections", err)
+		http.Error(w, "Error creating collection", http.StatusInternalServerError)
+		return
+	}
+	if count >= MaxCollections {
+		http.Error(w, fmt.Sprintf("You can have at most %d collections", MaxCollections), http.StatusBadRequest)
+		return
+	}
+
+	collectionID, err := uuid.NewV7()
+	if err != nil {
+		http.Error(w, "Failed to generate collection ID", http.StatusInternalServerError)
+		return
+	}
+	_, err = db.DB.Exec("INSERT INTO bookmark_collections (id, owner_id, name, created_at) VALUES (?, ?, ?, ?)",
+		collectionID.String(), userID, name, time.Now())
+	if err != nil {
+		logger.LogError("Error creating bookmark collection", err)
+		http.Error(w, "Error creating collection", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Collection created successfully", "collection_id": collectionID.String()})
+}
+
+func RenameCollection(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request CollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.CollectionID == "" {
+		http.Error(w, "Missing collection_id", http.StatusBadRequest)
+		return
+	}
+	if !checkCollectionOwner(w, userID, request.CollectionID) {
+		return
+	}
+	name, err := collectionName(userID, request.CollectionID, request.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := db.DB.Exec("UPDATE bookmark_collections SET name = ? WHERE id = ?", name, request.CollectionID); err != nil {
+		logger.LogError("Error renaming bookmark collection", err)
+		http.Error(w, "Error renaming collection", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Collection renamed successfully"})
+}
+
+// DeleteCollection keeps the bookmarks that were in it; they just become
+// unsorted.
+func DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodDelete)
+	if !ok {
+		return
+	}
+
+	collectionID := r.URL.Query().Get("collection_id")
+	if collectionID == "" {
+		http.Error(w, "Missing collection_id", http.StatusBadRequest)
+		return
+	}
+	if !checkCollectionOwner(w, userID, collectionID) {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"UPDATE bookmarks SET collection_id = NULL WHERE collection_id = ?",
+		"DELETE FROM bookmark_collections WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, collectionID); err != nil {
+			logger.LogError("Error deleting bookmark collection", err)
+			http.Error(w, "Error deleting collection", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Collection deleted successfully"})
+}
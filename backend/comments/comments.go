@@ -3,27 +3,57 @@ package comments
 import (
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"social-net/db"
-	"social-net/posts"
+	"social-net/filters"
+	"social-net/imaging"
+	"social-net/mentions"
+	"social-net/moderation"
+	"social-net/pagination"
 	"social-net/session"
+	"social-net/warnings"
 
 	"github.com/gofrs/uuid"
 )
 
 type Comments struct {
-	PostId        string
-	Comment       string    `json:"comment"`
-	Author        string    `json:"author"`
-	Avatar        string    `json:"avatar"`
-	Image         string    `json:"image"`
-	Creation_date time.Time `json:"creation_date"`
+	Id             string          `json:"id"`
+	TargetType     string          `json:"target_type"`
+	TargetID       string          `json:"target_id"`
+	Comment        string          `json:"comment"`
+	ContentSpans   []mentions.Span `json:"content_spans"`
+	ContentHTML    string          `json:"content_html"`
+	Author         string          `json:"author"`
+	Avatar         string          `json:"avatar"`
+	Image          string          `json:"image"`
+	Creation_date  time.Time       `json:"creation_date"`
+	Reactions      map[string]int  `json:"reactions"`
+	MyReaction     string          `json:"my_reaction"`
+	ContentWarning string          `json:"content_warning"`
+	Sensitive      bool            `json:"sensitive"`
+	Collapsed      bool            `json:"collapsed"`
+	MediaHidden    bool            `json:"media_hidden"`
+	Edited         bool            `json:"edited"`
+	EditedAt       string          `json:"edited_at"`
+	ParentID       string          `json:"parent_id"`
+	ReplyCount     int             `json:"reply_count"`
+	Replies        []Comments      `json:"replies,omitempty"`
+	// Content and GroupPostID repeat Comment and TargetID on group post
+	// comments, under the names group comment clients already read.
+	Content     string `json:"content,omitempty"`
+	GroupPostID string `json:"group_post_id,omitempty"`
+}
+
+// commentText reads the text of a comment; group comments used to send it as
+// content.
+func commentText(r *http.Request) string {
+	if text := r.FormValue("comment"); text != "" {
+		return strings.TrimSpace(text)
+	}
+	return strings.TrimSpace(r.FormValue("content"))
 }
 
 func AddComments(w http.ResponseWriter, r *http.Request) {
@@ -35,148 +65,131 @@ func AddComments(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	if r.Method == "POST" {
-
-		err := r.ParseMultipartForm(10 << 20)
-		if err != nil {
-			http.Error(w, "Failed to parse form", http.StatusBadRequest)
-			fmt.Println("Failed to parse form:", err)
-			return
-		}
+	if r.Method != "POST" {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
 
-		postId := r.FormValue("post_id")
-		commentText := r.FormValue("comment")
+	err := r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		fmt.Println("Failed to parse form:", err)
+		return
+	}
 
-		if postId == "" || commentText == "" {
-			http.Error(w, "Missing required fields", http.StatusBadRequest)
-			return
-		}
+	targetType, targetID, target, ok := targetFromRequest(w, r)
+	if !ok {
+		return
+	}
+	text := commentText(r)
+	if text == "" {
+		http.Error(w, "Comment must be at least 1 character long", http.StatusBadRequest)
+		return
+	}
+	if len(text) > 500 {
+		http.Error(w, "Comment must not exceed 500 characters", http.StatusBadRequest)
+		return
+	}
 
-		if len(commentText) < 1 {
-			http.Error(w, "Comment must be at least 1 character long", http.StatusBadRequest)
-			return
-		}
+	contentWarning, sensitive, err := warnings.FromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		if len(commentText) > 500 {
-			http.Error(w, "Comment must not exceed 500 characters", http.StatusBadRequest)
-			return
-		}
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+	userid, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userid == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+	username, ok := session.GetUsernameFromUserID(userid)
+	if !ok || username == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
 
-		token, err1 := r.Cookie("token")
-		if err1 != nil {
-			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
-			return
-		}
-		tokenn := token.Value
-		userid, ok := session.GetUserIDFromToken(tokenn)
-		if !ok || userid == "" {
-			fmt.Println("Unauthorized: Invalid token:", err)
-			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+	if !target.CanView(userid, targetID) {
+		http.Error(w, "Unauthorized: You cannot comment on this", http.StatusUnauthorized)
+		return
+	}
+	if target.CanComment != nil {
+		if allowed, reason := target.CanComment(userid, targetID); !allowed {
+			http.Error(w, "Forbidden: "+reason, http.StatusForbidden)
 			return
 		}
-		username, _ := session.GetUsernameFromUserID(userid)
+	}
 
-		if userid == "" || username == "" || tokenn == "" {
-			http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+	var parentID interface{}
+	var parentAuthor string
+	if id := r.FormValue("parent_id"); id != "" {
+		if parentAuthor, ok = checkParent(w, targetType, targetID, id); !ok {
 			return
 		}
+		parentID = id
+	}
 
-		allowed := posts.CheckUserPostPermission(userid, postId)
-		if !allowed {
-			http.Error(w, "Unauthorized: You cannot comment on this post", http.StatusUnauthorized)
-			return
-		}
+	groupID, err := groupOf(target, targetID)
+	if err != nil {
+		fmt.Println("Failed to resolve comment group:", err)
+		http.Error(w, "Failed to insert comment", http.StatusInternalServerError)
+		return
+	}
+	verdict, ok := filters.Apply(w, groupID, &text, &contentWarning)
+	if !ok {
+		return
+	}
 
-		commentID, err := uuid.NewV7()
-		if err != nil {
-			fmt.Println("Error generating comment ID:", err)
-			http.Error(w, "Failed to generate comment ID", http.StatusInternalServerError)
-			return
-		}
+	commentID, err := uuid.NewV7()
+	if err != nil {
+		fmt.Println("Error generating comment ID:", err)
+		http.Error(w, "Failed to generate comment ID", http.StatusInternalServerError)
+		return
+	}
 
-		var comment Comments
-		comment.PostId = postId
-		comment.Comment = commentText
-		comment.Author = username
-
-		file, handler, err := r.FormFile("image")
-		if err == nil && file != nil {
-			defer file.Close()
-
-			if handler.Size > 2*1024*1024 {
-				http.Error(w, "image file too large", http.StatusBadRequest)
-				return
-			}
-
-			buff := make([]byte, 512)
-			_, _ = file.Read(buff)
-			contentType := http.DetectContentType(buff)
-			file.Seek(0, io.SeekStart)
-
-			allowedTypes := []string{"image/jpeg", "image/png", "image/gif"}
-			valid := false
-			for _, t := range allowedTypes {
-				if t == contentType {
-					valid = true
-					break
-				}
-			}
-			if !valid {
-				http.Error(w, "Invalid image file type", http.StatusBadRequest)
-				return
-			}
-
-			ext := filepath.Ext(handler.Filename)
-			safeFilename := fmt.Sprintf("%s_%d%s", username, time.Now().Unix(), ext)
-
-			path := "./uploads"
-			_, err := os.Stat(path)
-			if os.IsNotExist(err) {
-				os.MkdirAll(path, os.ModePerm)
-			}
-			savePath := filepath.Join(path, safeFilename)
-
-			out, err := os.Create(savePath)
-			if err != nil {
-				log.Println("Failed to save image:", err)
-				http.Error(w, "Failed to save image", http.StatusInternalServerError)
-				return
-			}
-			defer out.Close()
-			_, err = io.Copy(out, file)
-			if err != nil {
-				log.Println("Failed to write image:", err)
-				http.Error(w, "Failed to write image", http.StatusInternalServerError)
-				return
-			}
-			comment.Image = safeFilename
-			fmt.Println("Image saved successfully:", safeFilename)
-		}
-		_, err = db.DB.Exec("INSERT INTO comments (id, post_id, author, content,image, creation_date) VALUES (?,?, ?, ?, ?, ?)",
-			commentID, comment.PostId, username, comment.Comment, comment.Image, time.Now())
-		if err != nil {
-			http.Error(w, "Failed to insert comment", http.StatusInternalServerError)
-			fmt.Println("Failed to insert comment:", err)
-			return
-		}
+	image, ok := saveImage(w, r, commentID.String())
+	if !ok {
+		return
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Comment created successfully",
-		})
-	} else {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	_, err = db.DB.Exec(`
+		INSERT INTO comments (id, target_type, target_id, author, content, image, creation_date, content_warning, sensitive, parent_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, commentID.String(), targetType, targetID, username, text, image, time.Now(), contentWarning, sensitive, parentID)
+	if err != nil {
+		imaging.Remove(image)
+		http.Error(w, "Failed to insert comment", http.StatusInternalServerError)
+		fmt.Println("Failed to insert comment:", err)
+		return
+	}
+	if err := verdict.Flag(db.DB, moderation.TargetComment, commentID.String(), groupID); err != nil {
+		fmt.Println("Failed to flag comment:", err)
 	}
+	mentions.Record(mentions.TargetComment, commentID.String(), userid, text)
+	notifyReply(parentAuthor, username, commentID.String())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Comment created successfully",
+		"id":      commentID.String(),
+	})
 }
 
-func CheckPostExists(postid string) bool {
-	var exists bool
-	err := db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", postid).Scan(&exists)
+// CheckUserCommentPermission reports whether userID can see what a comment
+// was posted on.
+func CheckUserCommentPermission(userID string, commentID string) bool {
+	var targetType, targetID string
+	err := db.DB.QueryRow("SELECT target_type, target_id FROM comments WHERE id = ?", commentID).Scan(&targetType, &targetID)
 	if err != nil {
-		fmt.Println("Error checking if post exists:", err)
+		fmt.Println("Error fetching comment:", err)
 		return false
 	}
-	return exists
+	target, ok := targets[targetType]
+	return ok && target.CanView(userID, targetID)
 }
 
 func Getcomments(w http.ResponseWriter, r *http.Request) {
@@ -188,58 +201,74 @@ func Getcomments(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	token, _err := r.Cookie("token")
-	if _err != nil {
+	token, err := r.Cookie("token")
+	if err != nil {
 		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
-
 		return
 	}
-	tokenn := token.Value
-	userid, ok := session.GetUserIDFromToken(tokenn)
+	userid, ok := session.GetUserIDFromToken(token.Value)
 	if !ok || userid == "" {
 		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
 		return
 	}
-	qu := r.URL.Query()
-	postid := qu.Get("post_id")
-	fmt.Println("postid", postid)
-	if postid == "" {
-		http.Error(w, "Missing postid parameter", http.StatusBadRequest)
+	targetType, targetID, target, ok := targetFromRequest(w, r)
+	if !ok {
 		return
 	}
-
-	if !CheckPostExists(postid) {
-		http.Error(w, "Post not found", http.StatusNotFound)
+	if !target.CanView(userid, targetID) {
+		http.Error(w, "Unauthorized: You do not have permission to view this", http.StatusUnauthorized)
 		return
 	}
-	allowed := posts.CheckUserPostPermission(userid, postid)
-	if !allowed {
-		http.Error(w, "Unauthorized: You do not have permission to view this post", http.StatusUnauthorized)
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	after, afterArgs := page.Where("c.creation_date", "c.id")
+	// top-level comments by default, or the direct replies to parent_id
+	level := "c.parent_id IS NULL"
+	args := []interface{}{targetType, targetID, userid}
+	if parentID := r.URL.Query().Get("parent_id"); parentID != "" {
+		level = "c.parent_id = ?"
+		args = append(args, parentID)
+	}
+	args = append(args, afterArgs...)
 	rows, err := db.DB.Query(`
-	SELECT c.post_id, c.content, c.author, u.avatar, c.image, c.creation_date
+	SELECT `+commentColumns+`, CAST(c.creation_date AS TEXT)
 	FROM comments c
 	LEFT JOIN users u ON c.author = u.username
-	WHERE c.post_id = ?
-	ORDER BY c.creation_date DESC
-`, postid)
+	WHERE c.target_type = ? AND c.target_id = ? AND (u.id = ? OR `+moderation.Visible(moderation.TargetComment, "c.id")+`) AND `+level+` AND `+after+`
+	ORDER BY c.creation_date DESC, c.id DESC
+	LIMIT ?
+`, append(args, page.Fetch())...)
 	if err != nil {
 		http.Error(w, "Failed to get comments", http.StatusInternalServerError)
 		fmt.Println("Failed to get comments:", err)
 		return
 	}
 	defer rows.Close()
-	var comments []Comments
+	preference := warnings.Preference(userid)
+	comments := []Comments{}
+	var scanned int
+	var last pagination.Cursor
 	for rows.Next() {
+		scanned++
+		if scanned > page.Limit {
+			break
+		}
 		var comment Comments
-		err := rows.Scan(&comment.PostId, &comment.Comment, &comment.Author, &comment.Avatar, &comment.Image, &comment.Creation_date)
+		err := rows.Scan(append(commentFields(&comment), &last.Time)...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			fmt.Println("Failed to scan comment:", err)
 			return
 		}
+		last.ID = comment.Id
+		fillComment(userid, preference, &comment)
 		comments = append(comments, comment)
 	}
-	json.NewEncoder(w).Encode(comments)
+	json.NewEncoder(w).Encode(pagination.Response{
+		Items:      comments,
+		NextCursor: page.Next(scanned, last),
+	})
 }
@@ -0,0 +1,187 @@
+package comments
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"social-net/db"
+	"social-net/filters"
+	"social-net/mentions"
+	"social-net/moderation"
+	"social-net/session"
+)
+
+type commentInfo struct {
+	targetType string
+	targetID   string
+	author     string
+	target     Target
+}
+
+func loadComment(w http.ResponseWriter, commentID string) (commentInfo, bool) {
+	var info commentInfo
+	err := db.DB.QueryRow("SELECT target_type, target_id, author FROM comments WHERE id = ?", commentID).
+		Scan(&info.targetType, &info.targetID, &info.author)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return info, false
+		}
+		fmt.Println("Failed to fetch comment:", err)
+		http.Error(w, "Failed to fetch comment", http.StatusInternalServerError)
+		return info, false
+	}
+	target, ok := targets[info.targetType]
+	if !ok {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return info, false
+	}
+	info.target = target
+	return info, true
+}
+
+func authenticate(w http.ResponseWriter, r *http.Request, method string) (string, string, bool) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", method+", OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return "", "", false
+	}
+	if r.Method != method {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return "", "", false
+	}
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return "", "", false
+	}
+	userid, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userid == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return "", "", false
+	}
+	username, ok := session.GetUsernameFromUserID(userid)
+	if !ok || username == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return "", "", false
+	}
+	return userid, username, true
+}
+
+// EditComment replaces the text of one of the caller's comments and marks it
+// as edited. The author must still be able to see what they commented on.
+func EditComment(w http.ResponseWriter, r *http.Request) {
+	userid, username, ok := authenticate(w, r, http.MethodPatch)
+	if !ok {
+		return
+	}
+	commentID := r.URL.Query().Get("comment_id")
+	if commentID == "" {
+		http.Error(w, "Missing comment_id parameter", http.StatusBadRequest)
+		return
+	}
+	info, ok := loadComment(w, commentID)
+	if !ok {
+		return
+	}
+	if info.author != username {
+		http.Error(w, "Forbidden: You can only edit your own comments", http.StatusForbidden)
+		return
+	}
+	if !info.target.CanView(userid, info.targetID) {
+		http.Error(w, "Unauthorized: You do not have permission to view this", http.StatusUnauthorized)
+		return
+	}
+
+	text := commentText(r)
+	if text == "" {
+		http.Error(w, "Comment must be at least 1 character long", http.StatusBadRequest)
+		return
+	}
+	if len(text) > 500 {
+		http.Error(w, "Comment must not exceed 500 characters", http.StatusBadRequest)
+		return
+	}
+	groupID, err := groupOf(info.target, info.targetID)
+	if err != nil {
+		fmt.Println("Failed to resolve comment group:", err)
+		http.Error(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+	verdict, ok := filters.Apply(w, groupID, &text)
+	if !ok {
+		return
+	}
+
+	_, err = db.DB.Exec("UPDATE comments SET content = ?, edited_at = ? WHERE id = ?", text, time.Now(), commentID)
+	if err != nil {
+		fmt.Println("Failed to update comment:", err)
+		http.Error(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+	if err := verdict.Flag(db.DB, moderation.TargetComment, commentID, groupID); err != nil {
+		fmt.Println("Failed to flag comment:", err)
+	}
+	mentions.Record(mentions.TargetComment, commentID, userid, text)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Comment updated successfully"})
+}
+
+// DeleteComment removes a comment. Authors can delete their own comments,
+// and whoever moderates the target (the post owner, or the admins of the
+// group) can remove any comment on it. Replies to it move up to its parent.
+func DeleteComment(w http.ResponseWriter, r *http.Request) {
+	userid, username, ok := authenticate(w, r, http.MethodDelete)
+	if !ok {
+		return
+	}
+	commentID := r.URL.Query().Get("comment_id")
+	if commentID == "" {
+		http.Error(w, "Missing comment_id parameter", http.StatusBadRequest)
+		return
+	}
+	info, ok := loadComment(w, commentID)
+	if !ok {
+		return
+	}
+	if info.author != username && (info.target.CanModerate == nil || !info.target.CanModerate(userid, info.targetID)) {
+		http.Error(w, "Forbidden: You cannot delete this comment", http.StatusForbidden)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		fmt.Println("Failed to start transaction:", err)
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"UPDATE comments SET parent_id = (SELECT parent_id FROM comments WHERE id = ?1) WHERE parent_id = ?1",
+		"DELETE FROM reactions WHERE target_type = 'comment' AND target_id = ?1",
+		"DELETE FROM mentions WHERE target_type = 'comment' AND target_id = ?1",
+		"DELETE FROM comments WHERE id = ?1",
+	} {
+		if _, err := tx.Exec(query, commentID); err != nil {
+			fmt.Println("Failed to delete comment:", err)
+			http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Failed to commit transaction:", err)
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Comment deleted successfully"})
+}
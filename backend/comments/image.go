@@ -0,0 +1,55 @@
+package comments
+
+import (
+	"io"
+	"log"
+	"net/http"
+
+	"social-net/imaging"
+)
+
+// MaxImageSize is the largest image a comment can carry.
+const MaxImageSize = 2 * 1024 * 1024
+
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// saveImage stores the optional image of a new comment in ./uploads and
+// returns its file name, or "" when none was sent. The name comes from the
+// comment ID and the decoded format, never from the client.
+func saveImage(w http.ResponseWriter, r *http.Request, commentID string) (string, bool) {
+	file, handler, err := r.FormFile("image")
+	if err != nil || file == nil {
+		return "", true
+	}
+	defer file.Close()
+
+	if handler.Size > MaxImageSize {
+		http.Error(w, "image file too large", http.StatusBadRequest)
+		return "", false
+	}
+
+	buff := make([]byte, 512)
+	n, _ := file.Read(buff)
+	if !allowedTypes[http.DetectContentType(buff[:n])] {
+		http.Error(w, "Invalid image file type", http.StatusBadRequest)
+		return "", false
+	}
+	file.Seek(0, io.SeekStart)
+
+	// re-encoding drops EXIF data such as the location a photo was taken
+	stored, err := imaging.Store(file, "comment_"+commentID)
+	if err == imaging.ErrInvalid || err == imaging.ErrTooLarge {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	if err != nil {
+		log.Println("Failed to save image:", err)
+		http.Error(w, "Failed to save image", http.StatusInternalServerError)
+		return "", false
+	}
+	return stored.Filename, true
+}
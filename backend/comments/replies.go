@@ -0,0 +1,201 @@
+package comments
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"social-net/db"
+	"social-net/mentions"
+	"social-net/moderation"
+	"social-net/notification"
+	"social-net/reactions"
+	"social-net/richtext"
+	"social-net/session"
+	"social-net/warnings"
+)
+
+const (
+	// MaxReplyDepth is how deep replies can nest; top-level comments are at
+	// depth 0.
+	MaxReplyDepth = 3
+	// MaxThreadSize caps how many comments one thread request returns.
+	MaxThreadSize = 500
+)
+
+const commentColumns = `c.id, c.target_type, c.target_id, c.content, c.author, u.avatar, c.image, c.creation_date, c.content_warning, c.sensitive,
+	COALESCE(CAST(c.edited_at AS TEXT), ''), COALESCE(c.parent_id, ''), (SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id)`
+
+func commentFields(c *Comments) []interface{} {
+	return []interface{}{&c.Id, &c.TargetType, &c.TargetID, &c.Comment, &c.Author, &c.Avatar, &c.Image, &c.Creation_date,
+		&c.ContentWarning, &c.Sensitive, &c.EditedAt, &c.ParentID, &c.ReplyCount}
+}
+
+func fillComment(userID, preference string, c *Comments) {
+	var err error
+	c.Edited = c.EditedAt != ""
+	c.ContentSpans = mentions.Spans(c.Comment)
+	c.ContentHTML = richtext.Render(c.Comment)
+	c.Collapsed, c.MediaHidden = warnings.Apply(preference, c.ContentWarning, c.Sensitive)
+	if c.MediaHidden {
+		c.Image = ""
+	}
+	c.Reactions, c.MyReaction, err = reactions.Summary(userID, reactions.TargetComment, c.Id)
+	if err != nil {
+		fmt.Println("Failed to count reactions:", err)
+	}
+	if c.TargetType == TargetGroupPost {
+		c.Content, c.GroupPostID = c.Comment, c.TargetID
+	}
+}
+
+// depth returns how many ancestors a comment has.
+func depth(commentID string) (int, error) {
+	var d int
+	err := db.DB.QueryRow(`
+		WITH RECURSIVE chain(id, parent_id, depth) AS (
+			SELECT id, parent_id, 0 FROM comments WHERE id = ?
+			UNION ALL
+			SELECT c.id, c.parent_id, chain.depth + 1 FROM comments c JOIN chain ON c.id = chain.parent_id
+		)
+		SELECT MAX(depth) FROM chain
+	`, commentID).Scan(&d)
+	return d, err
+}
+
+// checkParent validates the parent of a new reply: it must be a comment on
+// the same target and not already at the maximum depth. It returns the
+// parent's author.
+func checkParent(w http.ResponseWriter, targetType, targetID, parentID string) (string, bool) {
+	var parentType, parentTarget, author string
+	err := db.DB.QueryRow("SELECT target_type, target_id, author FROM comments WHERE id = ?", parentID).Scan(&parentType, &parentTarget, &author)
+	if err != nil || parentType != targetType || parentTarget != targetID {
+		http.Error(w, "Parent comment not found", http.StatusBadRequest)
+		return "", false
+	}
+	d, err := depth(parentID)
+	if err != nil {
+		fmt.Println("Failed to compute comment depth:", err)
+		http.Error(w, "Failed to insert comment", http.StatusInternalServerError)
+		return "", false
+	}
+	if d >= MaxReplyDepth {
+		http.Error(w, fmt.Sprintf("Replies cannot be nested more than %d levels deep", MaxReplyDepth), http.StatusBadRequest)
+		return "", false
+	}
+	return author, true
+}
+
+// notifyReply tells the author of the parent comment about a reply, unless
+// they replied to themselves.
+func notifyReply(parentAuthor, author, commentID string) {
+	if parentAuthor == "" || parentAuthor == author {
+		return
+	}
+	notification.CreateNotificationAbout(parentAuthor, author, notification.TypeReply,
+		author+" replied to your comment", notification.EntityComment, commentID)
+}
+
+// GetThread returns a comment with all of its replies nested under it,
+// oldest first at every level.
+func GetThread(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+	userid, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userid == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+	commentID := r.URL.Query().Get("comment_id")
+	if commentID == "" {
+		http.Error(w, "Missing comment_id parameter", http.StatusBadRequest)
+		return
+	}
+	if !CheckUserCommentPermission(userid, commentID) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+	WITH RECURSIVE thread(id) AS (
+		SELECT id FROM comments WHERE id = ?
+		UNION ALL
+		SELECT c.id FROM comments c JOIN thread ON c.parent_id = thread.id
+	)
+	SELECT `+commentColumns+`
+	FROM comments c
+	JOIN thread ON thread.id = c.id
+	LEFT JOIN users u ON c.author = u.username
+	WHERE u.id = ? OR `+moderation.Visible(moderation.TargetComment, "c.id")+`
+	ORDER BY c.creation_date, c.id
+	LIMIT ?
+`, commentID, userid, MaxThreadSize)
+	if err != nil {
+		http.Error(w, "Failed to get comments", http.StatusInternalServerError)
+		fmt.Println("Failed to get thread:", err)
+		return
+	}
+	preference := warnings.Preference(userid)
+	var thread []*Comments
+	for rows.Next() {
+		comment := &Comments{}
+		if err := rows.Scan(commentFields(comment)...); err != nil {
+			rows.Close()
+			http.Error(w, "Failed to get comments", http.StatusInternalServerError)
+			fmt.Println("Failed to scan comment:", err)
+			return
+		}
+		thread = append(thread, comment)
+	}
+	rows.Close()
+
+	var root *Comments
+	for _, comment := range thread {
+		if comment.Id == commentID {
+			root = comment
+		}
+	}
+	if root == nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	for _, comment := range thread {
+		fillComment(userid, preference, comment)
+	}
+	json.NewEncoder(w).Encode(nestReplies(root, thread))
+}
+
+// nestReplies copies the flat, oldest-first thread into a tree under root.
+// Replies whose parent was hidden are left out along with it.
+func nestReplies(root *Comments, thread []*Comments) Comments {
+	children := map[string][]*Comments{}
+	for _, comment := range thread {
+		if comment.Id != root.Id {
+			children[comment.ParentID] = append(children[comment.ParentID], comment)
+		}
+	}
+	var nest func(c *Comments) Comments
+	nest = func(c *Comments) Comments {
+		node := *c
+		for _, child := range children[c.Id] {
+			node.Replies = append(node.Replies, nest(child))
+		}
+		return node
+	}
+	return nest(root)
+}
@@ -0,0 +1,66 @@
+package comments
+
+import (
+	"net/http"
+)
+
+// Kinds of content that can be commented on.
+const (
+	TargetPost      = "post"
+	TargetGroupPost = "group_post"
+	TargetEvent     = "event"
+)
+
+// Target holds the rules for one kind of commentable content. They are
+// registered from main so this package doesn't import the packages that own
+// the content.
+type Target struct {
+	// CanView reports whether a user can see the target, and so read its
+	// comments.
+	CanView func(userID, targetID string) bool
+	// CanComment, when set, further limits who may add comments among the
+	// people who can see the target. It returns the reason when they can't.
+	CanComment func(userID, targetID string) (bool, string)
+	// CanModerate reports whether a user may remove anyone's comments on the
+	// target, like the owner of a post or the admins of a group.
+	CanModerate func(userID, targetID string) bool
+	// Group returns the group the target is in, or "" for content outside
+	// groups. It picks which word filters apply.
+	Group func(targetID string) (string, error)
+}
+
+var targets = map[string]Target{}
+
+func RegisterTarget(targetType string, target Target) {
+	targets[targetType] = target
+}
+
+// targetFromRequest reads the target_type and target_id of a request. The
+// older per-type endpoints send post_id or group_post_id instead.
+func targetFromRequest(w http.ResponseWriter, r *http.Request) (string, string, Target, bool) {
+	targetType, targetID := r.FormValue("target_type"), r.FormValue("target_id")
+	if targetType == "" {
+		if id := r.FormValue("post_id"); id != "" {
+			targetType, targetID = TargetPost, id
+		} else if id := r.FormValue("group_post_id"); id != "" {
+			targetType, targetID = TargetGroupPost, id
+		}
+	}
+	if targetID == "" {
+		http.Error(w, "Missing target_id parameter", http.StatusBadRequest)
+		return "", "", Target{}, false
+	}
+	target, ok := targets[targetType]
+	if !ok {
+		http.Error(w, "Invalid target_type", http.StatusBadRequest)
+		return "", "", Target{}, false
+	}
+	return targetType, targetID, target, true
+}
+
+func groupOf(target Target, targetID string) (string, error) {
+	if target.Group == nil {
+		return "", nil
+	}
+	return target.Group(targetID)
+}
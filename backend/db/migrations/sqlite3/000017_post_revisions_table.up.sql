@@ -0,0 +1,24 @@
+-- +migrate Up
+ALTER TABLE posts ADD COLUMN edited_at DATETIME;
+
+CREATE TABLE
+    IF NOT EXISTS post_revisions (
+        id TEXT PRIMARY KEY,
+        post_id TEXT NOT NULL,
+        title TEXT NOT NULL,
+        content TEXT NOT NULL,
+        image TEXT DEFAULT '',
+        edited_at DATETIME NOT NULL,
+        FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS idx_post_revisions_post_id ON post_revisions (post_id);
+
+-- +migrate Down
+PRAGMA foreign_keys = OFF;
+
+DROP TABLE IF EXISTS post_revisions;
+
+ALTER TABLE posts DROP COLUMN edited_at;
+
+PRAGMA foreign_keys = ON;
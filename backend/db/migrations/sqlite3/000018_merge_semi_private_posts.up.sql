@@ -0,0 +1,150 @@
+-- +migrate Up
+-- Semi-private posts used to be inserted once per allowed user. Copies of one
+-- post share author, title, content and image and were each created less than
+-- 5 seconds after the previous copy. Fold every run of copies back into its
+-- first row, so each duplicate points at a row that stays, and re-point what
+-- hung off the duplicates.
+CREATE TABLE
+    IF NOT EXISTS semi_private_merge AS
+WITH
+    ordered AS (
+        SELECT
+            id,
+            user_id,
+            title,
+            content,
+            COALESCE(image, '') AS image,
+            creation_date,
+            LAG (creation_date) OVER (
+                PARTITION BY
+                    user_id,
+                    title,
+                    content,
+                    COALESCE(image, '')
+                ORDER BY
+                    creation_date,
+                    id
+            ) AS previous_date
+        FROM
+            posts
+        WHERE
+            status = 'semi-private'
+    ),
+    runs AS (
+        SELECT
+            *,
+            SUM(
+                CASE
+                    WHEN previous_date IS NULL
+                    OR (julianday (creation_date) - julianday (previous_date)) * 86400 >= 5 THEN 1
+                    ELSE 0
+                END
+            ) OVER (
+                PARTITION BY
+                    user_id,
+                    title,
+                    content,
+                    image
+                ORDER BY
+                    creation_date,
+                    id
+            ) AS run
+        FROM
+            ordered
+    )
+SELECT
+    id AS old_id,
+    FIRST_VALUE (id) OVER (
+        PARTITION BY
+            user_id,
+            title,
+            content,
+            image,
+            run
+        ORDER BY
+            creation_date,
+            id
+    ) AS new_id
+FROM
+    runs;
+
+DELETE FROM semi_private_merge
+WHERE
+    old_id = new_id;
+
+UPDATE comments
+SET
+    post_id = (
+        SELECT
+            new_id
+        FROM
+            semi_private_merge
+        WHERE
+            old_id = comments.post_id
+    )
+WHERE
+    post_id IN (
+        SELECT
+            old_id
+        FROM
+            semi_private_merge
+    );
+
+UPDATE post_revisions
+SET
+    post_id = (
+        SELECT
+            new_id
+        FROM
+            semi_private_merge
+        WHERE
+            old_id = post_revisions.post_id
+    )
+WHERE
+    post_id IN (
+        SELECT
+            old_id
+        FROM
+            semi_private_merge
+    );
+
+UPDATE OR IGNORE postsPrivacy
+SET
+    post_id = (
+        SELECT
+            new_id
+        FROM
+            semi_private_merge
+        WHERE
+            old_id = postsPrivacy.post_id
+    )
+WHERE
+    post_id IN (
+        SELECT
+            old_id
+        FROM
+            semi_private_merge
+    );
+
+DELETE FROM postsPrivacy
+WHERE
+    post_id IN (
+        SELECT
+            old_id
+        FROM
+            semi_private_merge
+    );
+
+DELETE FROM posts
+WHERE
+    id IN (
+        SELECT
+            old_id
+        FROM
+            semi_private_merge
+    );
+
+DROP TABLE semi_private_merge;
+
+-- +migrate Down
+-- Merged copies cannot be split back apart; nothing to undo.
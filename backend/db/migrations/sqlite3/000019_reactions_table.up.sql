@@ -0,0 +1,21 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS reactions (
+        id TEXT PRIMARY KEY,
+        user_id TEXT NOT NULL,
+        target_type TEXT NOT NULL,
+        target_id TEXT NOT NULL,
+        reaction TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+        UNIQUE (user_id, target_type, target_id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_reactions_target ON reactions (target_type, target_id);
+
+-- +migrate Down
+PRAGMA foreign_keys = OFF;
+
+DROP TABLE IF EXISTS reactions;
+
+PRAGMA foreign_keys = ON;
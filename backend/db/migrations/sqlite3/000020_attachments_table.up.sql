@@ -0,0 +1,46 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS attachments (
+        id TEXT PRIMARY KEY,
+        target_type TEXT NOT NULL,
+        target_id TEXT NOT NULL,
+        filename TEXT NOT NULL,
+        alt_text TEXT DEFAULT '',
+        position INTEGER NOT NULL DEFAULT 0,
+        width INTEGER NOT NULL DEFAULT 0,
+        height INTEGER NOT NULL DEFAULT 0,
+        creation_date DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE INDEX IF NOT EXISTS idx_attachments_target ON attachments (target_type, target_id, position);
+
+INSERT INTO
+    attachments (id, target_type, target_id, filename, position)
+SELECT
+    'legacy_post_' || id,
+    'post',
+    id,
+    image,
+    0
+FROM
+    posts
+WHERE
+    image IS NOT NULL
+    AND image != '';
+
+INSERT INTO
+    attachments (id, target_type, target_id, filename, position)
+SELECT
+    'legacy_group_post_' || id,
+    'group_post',
+    id,
+    image,
+    0
+FROM
+    group_posts
+WHERE
+    image IS NOT NULL
+    AND image != '';
+
+-- +migrate Down
+DROP TABLE IF EXISTS attachments;
@@ -0,0 +1,13 @@
+-- +migrate Up
+ALTER TABLE posts ADD COLUMN state TEXT NOT NULL DEFAULT 'published' CHECK (state IN ('draft', 'scheduled', 'published'));
+
+ALTER TABLE posts ADD COLUMN publish_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_posts_state_publish_at ON posts (state, publish_at);
+
+-- +migrate Down
+DROP INDEX IF EXISTS idx_posts_state_publish_at;
+
+ALTER TABLE posts DROP COLUMN publish_at;
+
+ALTER TABLE posts DROP COLUMN state;
@@ -0,0 +1,21 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS post_tags (
+        id TEXT PRIMARY KEY,
+        tag TEXT NOT NULL,
+        target_type TEXT NOT NULL CHECK (target_type IN ('post', 'group_post')),
+        target_id TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        UNIQUE (tag, target_type, target_id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_post_tags_tag_created_at ON post_tags (tag, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_post_tags_target ON post_tags (target_type, target_id);
+
+-- +migrate Down
+PRAGMA foreign_keys = OFF;
+
+DROP TABLE IF EXISTS post_tags;
+
+PRAGMA foreign_keys = ON;
@@ -0,0 +1,22 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS mentions (
+        id TEXT PRIMARY KEY,
+        target_type TEXT NOT NULL,
+        target_id TEXT NOT NULL,
+        author_id TEXT NOT NULL,
+        mentioned_user_id TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        UNIQUE (target_type, target_id, mentioned_user_id),
+        FOREIGN KEY (author_id) REFERENCES users (id),
+        FOREIGN KEY (mentioned_user_id) REFERENCES users (id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_mentions_mentioned_user_id ON mentions (mentioned_user_id, created_at);
+
+-- +migrate Down
+PRAGMA foreign_keys = OFF;
+
+DROP TABLE IF EXISTS mentions;
+
+PRAGMA foreign_keys = ON;
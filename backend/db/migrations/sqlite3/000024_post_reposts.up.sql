@@ -0,0 +1,9 @@
+-- +migrate Up
+ALTER TABLE posts ADD COLUMN repost_of TEXT REFERENCES posts (id);
+
+CREATE INDEX IF NOT EXISTS idx_posts_repost_of ON posts (repost_of);
+
+-- +migrate Down
+DROP INDEX IF EXISTS idx_posts_repost_of;
+
+ALTER TABLE posts DROP COLUMN repost_of;
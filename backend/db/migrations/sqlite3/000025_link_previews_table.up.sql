@@ -0,0 +1,18 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS link_previews (
+        url TEXT PRIMARY KEY,
+        title TEXT NOT NULL DEFAULT '',
+        description TEXT NOT NULL DEFAULT '',
+        image TEXT NOT NULL DEFAULT '',
+        site_name TEXT NOT NULL DEFAULT '',
+        status TEXT NOT NULL CHECK (status IN ('ok', 'failed')),
+        fetched_at DATETIME NOT NULL
+    );
+
+-- +migrate Down
+PRAGMA foreign_keys = OFF;
+
+DROP TABLE IF EXISTS link_previews;
+
+PRAGMA foreign_keys = ON;
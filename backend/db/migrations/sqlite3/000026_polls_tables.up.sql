@@ -0,0 +1,52 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS polls (
+        id TEXT PRIMARY KEY,
+        target_type TEXT NOT NULL CHECK (target_type IN ('post', 'group_post')),
+        target_id TEXT NOT NULL,
+        creator_id TEXT NOT NULL,
+        multiple BOOLEAN NOT NULL DEFAULT 0,
+        anonymous BOOLEAN NOT NULL DEFAULT 1,
+        results_visibility TEXT NOT NULL DEFAULT 'always' CHECK (results_visibility IN ('always', 'after_vote', 'after_close')),
+        closes_at DATETIME,
+        created_at DATETIME NOT NULL,
+        UNIQUE (target_type, target_id),
+        FOREIGN KEY (creator_id) REFERENCES users (id)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS poll_options (
+        id TEXT PRIMARY KEY,
+        poll_id TEXT NOT NULL,
+        position INTEGER NOT NULL,
+        text TEXT NOT NULL,
+        FOREIGN KEY (poll_id) REFERENCES polls (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS idx_poll_options_poll_id ON poll_options (poll_id);
+
+CREATE TABLE
+    IF NOT EXISTS poll_votes (
+        id TEXT PRIMARY KEY,
+        poll_id TEXT NOT NULL,
+        option_id TEXT NOT NULL,
+        user_id TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        UNIQUE (poll_id, option_id, user_id),
+        FOREIGN KEY (poll_id) REFERENCES polls (id) ON DELETE CASCADE,
+        FOREIGN KEY (option_id) REFERENCES poll_options (id) ON DELETE CASCADE,
+        FOREIGN KEY (user_id) REFERENCES users (id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_poll_votes_poll_id ON poll_votes (poll_id, user_id);
+
+-- +migrate Down
+PRAGMA foreign_keys = OFF;
+
+DROP TABLE IF EXISTS poll_votes;
+
+DROP TABLE IF EXISTS poll_options;
+
+DROP TABLE IF EXISTS polls;
+
+PRAGMA foreign_keys = ON;
@@ -0,0 +1,44 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS audience_lists (
+        id TEXT PRIMARY KEY,
+        owner_id TEXT NOT NULL,
+        name TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        UNIQUE (owner_id, name),
+        FOREIGN KEY (owner_id) REFERENCES users (id)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS audience_list_members (
+        list_id TEXT NOT NULL,
+        user_id TEXT NOT NULL,
+        added_at DATETIME NOT NULL,
+        PRIMARY KEY (list_id, user_id),
+        FOREIGN KEY (list_id) REFERENCES audience_lists (id) ON DELETE CASCADE,
+        FOREIGN KEY (user_id) REFERENCES users (id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_audience_list_members_user_id ON audience_list_members (user_id);
+
+CREATE TABLE
+    IF NOT EXISTS post_audience_lists (
+        post_id TEXT NOT NULL,
+        list_id TEXT NOT NULL,
+        PRIMARY KEY (post_id, list_id),
+        FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+        FOREIGN KEY (list_id) REFERENCES audience_lists (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX IF NOT EXISTS idx_post_audience_lists_list_id ON post_audience_lists (list_id);
+
+-- +migrate Down
+PRAGMA foreign_keys = OFF;
+
+DROP TABLE IF EXISTS post_audience_lists;
+
+DROP TABLE IF EXISTS audience_list_members;
+
+DROP TABLE IF EXISTS audience_lists;
+
+PRAGMA foreign_keys = ON;
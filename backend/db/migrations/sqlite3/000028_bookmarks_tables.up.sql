@@ -0,0 +1,36 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS bookmark_collections (
+        id TEXT PRIMARY KEY,
+        owner_id TEXT NOT NULL,
+        name TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        UNIQUE (owner_id, name),
+        FOREIGN KEY (owner_id) REFERENCES users (id)
+    );
+
+CREATE TABLE
+    IF NOT EXISTS bookmarks (
+        id TEXT PRIMARY KEY,
+        user_id TEXT NOT NULL,
+        collection_id TEXT,
+        target_type TEXT NOT NULL CHECK (target_type IN ('post', 'group_post')),
+        target_id TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        UNIQUE (user_id, target_type, target_id),
+        FOREIGN KEY (user_id) REFERENCES users (id),
+        FOREIGN KEY (collection_id) REFERENCES bookmark_collections (id) ON DELETE SET NULL
+    );
+
+CREATE INDEX IF NOT EXISTS idx_bookmarks_user_created ON bookmarks (user_id, created_at, id);
+
+CREATE INDEX IF NOT EXISTS idx_bookmarks_target ON bookmarks (target_type, target_id);
+
+-- +migrate Down
+PRAGMA foreign_keys = OFF;
+
+DROP TABLE IF EXISTS bookmarks;
+
+DROP TABLE IF EXISTS bookmark_collections;
+
+PRAGMA foreign_keys = ON;
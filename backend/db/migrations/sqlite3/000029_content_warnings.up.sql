@@ -0,0 +1,29 @@
+-- +migrate Up
+ALTER TABLE posts ADD COLUMN content_warning TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE posts ADD COLUMN sensitive BOOLEAN NOT NULL DEFAULT 0;
+
+ALTER TABLE comments ADD COLUMN content_warning TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE comments ADD COLUMN sensitive BOOLEAN NOT NULL DEFAULT 0;
+
+ALTER TABLE group_posts ADD COLUMN content_warning TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE group_posts ADD COLUMN sensitive BOOLEAN NOT NULL DEFAULT 0;
+
+ALTER TABLE users ADD COLUMN sensitive_media TEXT NOT NULL DEFAULT 'collapse' CHECK (sensitive_media IN ('collapse', 'show', 'hide'));
+
+-- +migrate Down
+ALTER TABLE users DROP COLUMN sensitive_media;
+
+ALTER TABLE group_posts DROP COLUMN sensitive;
+
+ALTER TABLE group_posts DROP COLUMN content_warning;
+
+ALTER TABLE comments DROP COLUMN sensitive;
+
+ALTER TABLE comments DROP COLUMN content_warning;
+
+ALTER TABLE posts DROP COLUMN sensitive;
+
+ALTER TABLE posts DROP COLUMN content_warning;
@@ -0,0 +1,24 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS post_views (
+        post_id TEXT NOT NULL,
+        viewer_id TEXT NOT NULL,
+        day TEXT NOT NULL,
+        impressions INTEGER NOT NULL DEFAULT 0,
+        PRIMARY KEY (post_id, viewer_id, day),
+        FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+        FOREIGN KEY (viewer_id) REFERENCES users (id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_post_views_day ON post_views (day);
+
+ALTER TABLE Followers ADD COLUMN accepted_at DATETIME;
+
+-- +migrate Down
+ALTER TABLE Followers DROP COLUMN accepted_at;
+
+PRAGMA foreign_keys = OFF;
+
+DROP TABLE IF EXISTS post_views;
+
+PRAGMA foreign_keys = ON;
@@ -0,0 +1,47 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS reports (
+        id TEXT PRIMARY KEY,
+        reporter_id TEXT NOT NULL,
+        target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment', 'group_post', 'group_comment', 'message', 'group_message', 'profile')),
+        target_id TEXT NOT NULL,
+        group_id TEXT,
+        reason TEXT NOT NULL CHECK (reason IN ('spam', 'harassment', 'hate', 'violence', 'nudity', 'misinformation', 'other')),
+        details TEXT NOT NULL DEFAULT '',
+        status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'actioned', 'dismissed')),
+        created_at DATETIME NOT NULL,
+        resolved_by TEXT,
+        resolved_at DATETIME,
+        resolution_note TEXT NOT NULL DEFAULT '',
+        UNIQUE (reporter_id, target_type, target_id),
+        FOREIGN KEY (reporter_id) REFERENCES users (id),
+        FOREIGN KEY (resolved_by) REFERENCES users (id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_reports_status_created ON reports (status, created_at, id);
+
+CREATE INDEX IF NOT EXISTS idx_reports_target ON reports (target_type, target_id);
+
+CREATE INDEX IF NOT EXISTS idx_reports_group_id ON reports (group_id);
+
+CREATE TABLE
+    IF NOT EXISTS hidden_content (
+        target_type TEXT NOT NULL,
+        target_id TEXT NOT NULL,
+        reason TEXT NOT NULL CHECK (reason IN ('reports', 'moderator')),
+        hidden_at DATETIME NOT NULL,
+        PRIMARY KEY (target_type, target_id)
+    );
+
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator'));
+
+-- +migrate Down
+ALTER TABLE users DROP COLUMN role;
+
+PRAGMA foreign_keys = OFF;
+
+DROP TABLE IF EXISTS hidden_content;
+
+DROP TABLE IF EXISTS reports;
+
+PRAGMA foreign_keys = ON;
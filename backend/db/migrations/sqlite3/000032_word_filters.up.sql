@@ -0,0 +1,102 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS word_filters (
+        id TEXT PRIMARY KEY,
+        group_id TEXT,
+        pattern TEXT NOT NULL,
+        match TEXT NOT NULL DEFAULT 'word' CHECK (match IN ('word', 'substring')),
+        action TEXT NOT NULL CHECK (action IN ('block', 'flag', 'mask')),
+        created_by TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        FOREIGN KEY (group_id) REFERENCES groups (id) ON DELETE CASCADE,
+        FOREIGN KEY (created_by) REFERENCES users (id)
+    );
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_word_filters_scope_pattern ON word_filters (COALESCE(group_id, ''), pattern);
+
+CREATE TABLE
+    IF NOT EXISTS muted_words (
+        user_id TEXT NOT NULL,
+        word TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        PRIMARY KEY (user_id, word),
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+-- Reports raised by a word filter have no reporter, which needs a table
+-- rebuild to relax the NOT NULL.
+CREATE TABLE
+    reports_new (
+        id TEXT PRIMARY KEY,
+        reporter_id TEXT,
+        source TEXT NOT NULL DEFAULT 'user' CHECK (source IN ('user', 'filter')),
+        target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment', 'group_post', 'group_comment', 'message', 'group_message', 'profile')),
+        target_id TEXT NOT NULL,
+        group_id TEXT,
+        reason TEXT NOT NULL CHECK (reason IN ('spam', 'harassment', 'hate', 'violence', 'nudity', 'misinformation', 'other')),
+        details TEXT NOT NULL DEFAULT '',
+        status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'actioned', 'dismissed')),
+        created_at DATETIME NOT NULL,
+        resolved_by TEXT,
+        resolved_at DATETIME,
+        resolution_note TEXT NOT NULL DEFAULT '',
+        UNIQUE (reporter_id, target_type, target_id),
+        FOREIGN KEY (reporter_id) REFERENCES users (id),
+        FOREIGN KEY (resolved_by) REFERENCES users (id)
+    );
+
+INSERT INTO reports_new (id, reporter_id, target_type, target_id, group_id, reason, details, status, created_at, resolved_by, resolved_at, resolution_note)
+SELECT id, reporter_id, target_type, target_id, group_id, reason, details, status, created_at, resolved_by, resolved_at, resolution_note FROM reports;
+
+DROP TABLE reports;
+
+ALTER TABLE reports_new RENAME TO reports;
+
+CREATE INDEX IF NOT EXISTS idx_reports_status_created ON reports (status, created_at, id);
+
+CREATE INDEX IF NOT EXISTS idx_reports_target ON reports (target_type, target_id);
+
+CREATE INDEX IF NOT EXISTS idx_reports_group_id ON reports (group_id);
+
+-- +migrate Down
+DELETE FROM reports WHERE reporter_id IS NULL;
+
+CREATE TABLE
+    reports_old (
+        id TEXT PRIMARY KEY,
+        reporter_id TEXT NOT NULL,
+        target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment', 'group_post', 'group_comment', 'message', 'group_message', 'profile')),
+        target_id TEXT NOT NULL,
+        group_id TEXT,
+        reason TEXT NOT NULL CHECK (reason IN ('spam', 'harassment', 'hate', 'violence', 'nudity', 'misinformation', 'other')),
+        details TEXT NOT NULL DEFAULT '',
+        status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'actioned', 'dismissed')),
+        created_at DATETIME NOT NULL,
+        resolved_by TEXT,
+        resolved_at DATETIME,
+        resolution_note TEXT NOT NULL DEFAULT '',
+        UNIQUE (reporter_id, target_type, target_id),
+        FOREIGN KEY (reporter_id) REFERENCES users (id),
+        FOREIGN KEY (resolved_by) REFERENCES users (id)
+    );
+
+INSERT INTO reports_old (id, reporter_id, target_type, target_id, group_id, reason, details, status, created_at, resolved_by, resolved_at, resolution_note)
+SELECT id, reporter_id, target_type, target_id, group_id, reason, details, status, created_at, resolved_by, resolved_at, resolution_note FROM reports;
+
+DROP TABLE reports;
+
+ALTER TABLE reports_old RENAME TO reports;
+
+CREATE INDEX IF NOT EXISTS idx_reports_status_created ON reports (status, created_at, id);
+
+CREATE INDEX IF NOT EXISTS idx_reports_target ON reports (target_type, target_id);
+
+CREATE INDEX IF NOT EXISTS idx_reports_group_id ON reports (group_id);
+
+PRAGMA foreign_keys = OFF;
+
+DROP TABLE IF EXISTS muted_words;
+
+DROP TABLE IF EXISTS word_filters;
+
+PRAGMA foreign_keys = ON;
@@ -0,0 +1,17 @@
+-- +migrate Up
+ALTER TABLE comments ADD COLUMN parent_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments (parent_id);
+
+ALTER TABLE group_comments ADD COLUMN parent_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_group_comments_parent_id ON group_comments (parent_id);
+
+-- +migrate Down
+DROP INDEX IF EXISTS idx_group_comments_parent_id;
+
+ALTER TABLE group_comments DROP COLUMN parent_id;
+
+DROP INDEX IF EXISTS idx_comments_parent_id;
+
+ALTER TABLE comments DROP COLUMN parent_id;
@@ -0,0 +1,9 @@
+-- +migrate Up
+ALTER TABLE comments ADD COLUMN edited_at DATETIME;
+
+ALTER TABLE group_comments ADD COLUMN edited_at DATETIME;
+
+-- +migrate Down
+ALTER TABLE group_comments DROP COLUMN edited_at;
+
+ALTER TABLE comments DROP COLUMN edited_at;
@@ -0,0 +1,126 @@
+-- +migrate Up
+PRAGMA foreign_keys = OFF;
+
+CREATE TABLE
+    IF NOT EXISTS comments_unified (
+        id TEXT PRIMARY KEY NOT NULL,
+        target_type TEXT NOT NULL DEFAULT 'post',
+        target_id TEXT NOT NULL,
+        author TEXT NOT NULL,
+        content TEXT NOT NULL,
+        image TEXT DEFAULT '',
+        creation_date DATETIME NOT NULL,
+        content_warning TEXT NOT NULL DEFAULT '',
+        sensitive BOOLEAN NOT NULL DEFAULT 0,
+        parent_id TEXT,
+        edited_at DATETIME
+    );
+
+INSERT INTO
+    comments_unified (id, target_type, target_id, author, content, image, creation_date, content_warning, sensitive, parent_id, edited_at)
+SELECT
+    id, 'post', post_id, author, content, image, creation_date, content_warning, sensitive, parent_id, edited_at
+FROM
+    comments;
+
+INSERT INTO
+    comments_unified (id, target_type, target_id, author, content, image, creation_date, parent_id, edited_at)
+SELECT
+    id, 'group_post', group_post_id, author, content, image, creation_date, parent_id, edited_at
+FROM
+    group_comments;
+
+DROP TABLE comments;
+
+DROP TABLE group_comments;
+
+ALTER TABLE comments_unified RENAME TO comments;
+
+CREATE INDEX IF NOT EXISTS idx_comments_target ON comments (target_type, target_id, creation_date);
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments (parent_id);
+
+-- Comment IDs are unique across the merged table, so everything that refers
+-- to a comment can use the same type whatever it was posted on.
+UPDATE reports SET target_type = 'comment' WHERE target_type = 'group_comment';
+
+UPDATE hidden_content SET target_type = 'comment' WHERE target_type = 'group_comment';
+
+UPDATE mentions SET target_type = 'comment' WHERE target_type = 'group_comment';
+
+UPDATE notifications SET related_entity_type = 'comment' WHERE related_entity_type = 'group_comment';
+
+PRAGMA foreign_keys = ON;
+
+-- +migrate Down
+PRAGMA foreign_keys = OFF;
+
+DELETE FROM comments WHERE target_type NOT IN ('post', 'group_post');
+
+UPDATE reports SET target_type = 'group_comment'
+WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE target_type = 'group_post');
+
+UPDATE hidden_content SET target_type = 'group_comment'
+WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE target_type = 'group_post');
+
+UPDATE mentions SET target_type = 'group_comment'
+WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE target_type = 'group_post');
+
+UPDATE notifications SET related_entity_type = 'group_comment'
+WHERE related_entity_type = 'comment' AND related_entity_id IN (SELECT id FROM comments WHERE target_type = 'group_post');
+
+CREATE TABLE
+    IF NOT EXISTS group_comments (
+        id TEXT PRIMARY KEY NOT NULL,
+        group_post_id TEXT NOT NULL,
+        author TEXT NOT NULL,
+        content TEXT NOT NULL,
+        creation_date DATETIME NOT NULL,
+        image TEXT DEFAULT '',
+        parent_id TEXT,
+        edited_at DATETIME,
+        FOREIGN KEY (group_post_id) REFERENCES group_posts (id)
+    );
+
+INSERT INTO
+    group_comments (id, group_post_id, author, content, creation_date, image, parent_id, edited_at)
+SELECT
+    id, target_id, author, content, creation_date, image, parent_id, edited_at
+FROM
+    comments
+WHERE
+    target_type = 'group_post';
+
+CREATE INDEX IF NOT EXISTS idx_group_comments_parent_id ON group_comments (parent_id);
+
+CREATE TABLE
+    IF NOT EXISTS comments_split (
+        id TEXT PRIMARY KEY NOT NULL,
+        post_id TEXT NOT NULL,
+        author TEXT NOT NULL,
+        content TEXT NOT NULL,
+        image TEXT DEFAULT '',
+        creation_date DATETIME NOT NULL,
+        content_warning TEXT NOT NULL DEFAULT '',
+        sensitive BOOLEAN NOT NULL DEFAULT 0,
+        parent_id TEXT,
+        edited_at DATETIME,
+        FOREIGN KEY (post_id) REFERENCES posts (id)
+    );
+
+INSERT INTO
+    comments_split (id, post_id, author, content, image, creation_date, content_warning, sensitive, parent_id, edited_at)
+SELECT
+    id, target_id, author, content, image, creation_date, content_warning, sensitive, parent_id, edited_at
+FROM
+    comments
+WHERE
+    target_type = 'post';
+
+DROP TABLE comments;
+
+ALTER TABLE comments_split RENAME TO comments;
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments (parent_id);
+
+PRAGMA foreign_keys = ON;
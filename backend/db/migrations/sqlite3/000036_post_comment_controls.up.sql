@@ -0,0 +1,9 @@
+-- +migrate Up
+ALTER TABLE posts ADD COLUMN comment_policy TEXT NOT NULL DEFAULT 'everyone' CHECK (comment_policy IN ('everyone', 'followers', 'mentioned', 'nobody'));
+
+ALTER TABLE posts ADD COLUMN comments_locked BOOLEAN NOT NULL DEFAULT 0;
+
+-- +migrate Down
+ALTER TABLE posts DROP COLUMN comments_locked;
+
+ALTER TABLE posts DROP COLUMN comment_policy;
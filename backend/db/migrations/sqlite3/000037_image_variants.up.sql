@@ -0,0 +1,14 @@
+-- +migrate Up
+CREATE TABLE
+    IF NOT EXISTS image_variants (
+        filename TEXT NOT NULL,
+        size TEXT NOT NULL CHECK (size IN ('original', 'medium', 'thumb')),
+        variant TEXT NOT NULL,
+        width INTEGER NOT NULL,
+        height INTEGER NOT NULL,
+        created_at DATETIME NOT NULL,
+        PRIMARY KEY (filename, size)
+    );
+
+-- +migrate Down
+DROP TABLE IF EXISTS image_variants;
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"social-net/db"
+	"social-net/moderation"
 	"social-net/notification"
 	"social-net/session"
 
@@ -477,3 +478,37 @@ func GetEvents(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(events)
 }
+
+// CheckUserEventPermission reports whether userID is an accepted member of
+// the group an event belongs to.
+func CheckUserEventPermission(userID string, eventID string) bool {
+	var exists bool
+	err := db.DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM events e
+			JOIN group_members gm ON gm.group_id = e.group_id
+			WHERE e.id = ? AND gm.user_id = ? AND gm.status = 'accepted'
+		)`, eventID, userID).Scan(&exists)
+	if err != nil {
+		log.Println("Error checking event permission:", err)
+		return false
+	}
+	return exists
+}
+
+// CanModerateEvent reports whether userID created an event or administers
+// its group.
+func CanModerateEvent(userID string, eventID string) bool {
+	var creatorID, groupID string
+	if err := db.DB.QueryRow("SELECT creator_id, group_id FROM events WHERE id = ?", eventID).Scan(&creatorID, &groupID); err != nil {
+		return false
+	}
+	return creatorID == userID || moderation.IsGroupAdmin(userID, groupID)
+}
+
+// GroupOfEvent returns the group an event belongs to.
+func GroupOfEvent(eventID string) (string, error) {
+	var groupID string
+	err := db.DB.QueryRow("SELECT group_id FROM events WHERE id = ?", eventID).Scan(&groupID)
+	return groupID, err
+}
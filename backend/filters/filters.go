@@ -0,0 +1,248 @@
+package filters
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/moderation"
+)
+
+const (
+	ActionBlock = "block"
+	ActionFlag  = "flag"
+	ActionMask  = "mask"
+
+	MatchWord      = "word"
+	MatchSubstring = "substring"
+
+	MaxPatternLength = 100
+	MaxMutedWords    = 100
+
+	BlockedMessage = "Your message contains words that aren't allowed here"
+)
+
+type Rule struct {
+	ID        string `json:"id"`
+	GroupID   string `json:"group_id"`
+	Pattern   string `json:"pattern"`
+	Match     string `json:"match"`
+	Action    string `json:"action"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Verdict is what the rules decided about a piece of content. Masking has
+// already been applied to the fields by the time it is returned.
+type Verdict struct {
+	Blocked bool
+	Flagged bool
+	Matched []string
+}
+
+// Rules are read on every post, comment and chat message, so each scope is
+// loaded once and dropped whenever its rules change.
+var (
+	cacheMu sync.RWMutex
+	cache   = map[string][]Rule{}
+)
+
+func invalidate(groupID string) {
+	cacheMu.Lock()
+	delete(cache, groupID)
+	cacheMu.Unlock()
+}
+
+// rules returns the rules of one scope; "" is the site-wide scope.
+func rules(groupID string) ([]Rule, error) {
+	cacheMu.RLock()
+	cached, ok := cache[groupID]
+	cacheMu.RUnlock()
+	if ok {
+		return cached, nil
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT id, COALESCE(group_id, ''), pattern, match, action, created_at
+		FROM word_filters WHERE COALESCE(group_id, '') = ?
+		ORDER BY created_at
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	loaded := []Rule{}
+	for rows.Next() {
+		var rule Rule
+		if err := rows.Scan(&rule.ID, &rule.GroupID, &rule.Pattern, &rule.Match, &rule.Action, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	cacheMu.Lock()
+	cache[groupID] = loaded
+	cacheMu.Unlock()
+	return loaded, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func lower(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+// find returns the rune offsets of every case-insensitive occurrence of
+// pattern in text. Word matches must not touch a letter or digit on
+// either side, so "ass" doesn't match "class".
+func find(text, pattern []rune, wholeWord bool) [][2]int {
+	var found [][2]int
+	if len(pattern) == 0 {
+		return nil
+	}
+	for i := 0; i+len(pattern) <= len(text); i++ {
+		match := true
+		for j := range pattern {
+			if text[i+j] != pattern[j] {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		end := i + len(pattern)
+		if wholeWord && ((i > 0 && isWordRune(text[i-1])) || (end < len(text) && isWordRune(text[end]))) {
+			continue
+		}
+		found = append(found, [2]int{i, end})
+		i = end - 1
+	}
+	return found
+}
+
+// Contains reports whether any of the words appears in text as a whole word.
+func Contains(text string, words []string) bool {
+	runes := lower(text)
+	for _, word := range words {
+		if len(find(runes, lower(word), true)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Check runs the site-wide rules, and those of groupID when it is set, over
+// the given fields. Mask rules rewrite the fields in place, replacing each
+// matched character with '*'.
+func Check(groupID string, fields ...*string) (Verdict, error) {
+	var verdict Verdict
+	active, err := rules("")
+	if err != nil {
+		return verdict, err
+	}
+	if groupID != "" {
+		groupRules, err := rules(groupID)
+		if err != nil {
+			return verdict, err
+		}
+		active = append(append([]Rule{}, active...), groupRules...)
+	}
+	if len(active) == 0 {
+		return verdict, nil
+	}
+
+	matched := map[string]bool{}
+	for _, field := range fields {
+		text := []rune(*field)
+		lowered := lower(*field)
+		masked := false
+		for _, rule := range active {
+			found := find(lowered, lower(rule.Pattern), rule.Match == MatchWord)
+			if len(found) == 0 {
+				continue
+			}
+			if !matched[rule.Pattern] {
+				matched[rule.Pattern] = true
+				verdict.Matched = append(verdict.Matched, rule.Pattern)
+			}
+			switch rule.Action {
+			case ActionBlock:
+				verdict.Blocked = true
+			case ActionFlag:
+				verdict.Flagged = true
+			case ActionMask:
+				for _, span := range found {
+					for i := span[0]; i < span[1]; i++ {
+						if !unicode.IsSpace(text[i]) {
+							text[i] = '*'
+						}
+					}
+				}
+				masked = true
+			}
+		}
+		if masked {
+			*field = string(text)
+		}
+	}
+	return verdict, nil
+}
+
+// Apply is Check for handlers: it writes the error response itself when the
+// content is blocked or the rules can't be read.
+func Apply(w http.ResponseWriter, groupID string, fields ...*string) (Verdict, bool) {
+	verdict, err := Check(groupID, fields...)
+	if err != nil {
+		logger.LogError("Error applying word filters", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return verdict, false
+	}
+	if verdict.Blocked {
+		http.Error(w, BlockedMessage, http.StatusBadRequest)
+		return verdict, false
+	}
+	return verdict, true
+}
+
+// Flag sends flagged content to the moderation queue; it does nothing for
+// content no flag rule matched.
+func (v Verdict) Flag(exec moderation.Execer, targetType, targetID, groupID string) error {
+	if !v.Flagged {
+		return nil
+	}
+	return moderation.Flag(exec, targetType, targetID, groupID, v.Matched)
+}
+
+// MutedWords returns the words userID has muted.
+func MutedWords(userID string) []string {
+	rows, err := db.DB.Query("SELECT word FROM muted_words WHERE user_id = ?", userID)
+	if err != nil {
+		logger.LogError("Error fetching muted words", err)
+		return nil
+	}
+	defer rows.Close()
+	var words []string
+	for rows.Next() {
+		var word string
+		if err := rows.Scan(&word); err == nil {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func normalizePattern(pattern string) string {
+	return strings.Join(strings.Fields(strings.ToLower(pattern)), " ")
+}
@@ -0,0 +1,251 @@
+package filters
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/moderation"
+	"social-net/session"
+
+	"github.com/gofrs/uuid"
+)
+
+type RuleRequest struct {
+	ID      string `json:"id"`
+	GroupID string `json:"group_id"`
+	Pattern string `json:"pattern"`
+	Match   string `json:"match"`
+	Action  string `json:"action"`
+}
+
+type MuteRequest struct {
+	Word string `json:"word"`
+}
+
+func authenticate(w http.ResponseWriter, r *http.Request, method string) (string, bool) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", method+", OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return "", false
+	}
+	if r.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return "", false
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+// canManage checks that userID may edit the rules of a scope: moderators
+// manage every scope, group admins their own group's.
+func canManage(w http.ResponseWriter, userID, groupID string) bool {
+	if moderation.IsModerator(userID) || (groupID != "" && moderation.IsGroupAdmin(userID, groupID)) {
+		return true
+	}
+	http.Error(w, "Forbidden: You cannot manage these filters", http.StatusForbidden)
+	return false
+}
+
+// GetRules lists the site-wide rules, or a group's with ?group_id=.
+func GetRules(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+	groupID := r.URL.Query().Get("group_id")
+	if !canManage(w, userID, groupID) {
+		return
+	}
+
+	list, err := rules(groupID)
+	if err != nil {
+		logger.LogError("Error fetching word filters", err)
+		http.Error(w, "Error fetching filters", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func CreateRule(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request RuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	request.Pattern = normalizePattern(request.Pattern)
+	if request.Pattern == "" || len([]rune(request.Pattern)) > MaxPatternLength {
+		http.Error(w, fmt.Sprintf("Pattern must be between 1 and %d characters", MaxPatternLength), http.StatusBadRequest)
+		return
+	}
+	if request.Match == "" {
+		request.Match = MatchWord
+	}
+	if request.Match != MatchWord && request.Match != MatchSubstring {
+		http.Error(w, "Invalid match type", http.StatusBadRequest)
+		return
+	}
+	if request.Action != ActionBlock && request.Action != ActionFlag && request.Action != ActionMask {
+		http.Error(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+	if !canManage(w, userID, request.GroupID) {
+		return
+	}
+
+	var group interface{}
+	if request.GroupID != "" {
+		group = request.GroupID
+	}
+	ruleID, err := uuid.NewV7()
+	if err != nil {
+		http.Error(w, "Failed to generate filter ID", http.StatusInternalServerError)
+		return
+	}
+	result, err := db.DB.Exec(`
+		INSERT OR IGNORE INTO word_filters (id, group_id, pattern, match, action, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, ruleID.String(), group, request.Pattern, request.Match, request.Action, userID, time.Now())
+	if err != nil {
+		logger.LogError("Error creating word filter", err)
+		http.Error(w, "Error creating filter", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "A filter for this pattern already exists", http.StatusConflict)
+		return
+	}
+	invalidate(request.GroupID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Filter created", "id": ruleID.String()})
+}
+
+func DeleteRule(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request RuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	var groupID string
+	err := db.DB.QueryRow("SELECT COALESCE(group_id, '') FROM word_filters WHERE id = ?", request.ID).Scan(&groupID)
+	if err != nil {
+		http.Error(w, "Filter not found", http.StatusNotFound)
+		return
+	}
+	if !canManage(w, userID, groupID) {
+		return
+	}
+	if _, err := db.DB.Exec("DELETE FROM word_filters WHERE id = ?", request.ID); err != nil {
+		logger.LogError("Error deleting word filter", err)
+		http.Error(w, "Error deleting filter", http.StatusInternalServerError)
+		return
+	}
+	invalidate(groupID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Filter deleted"})
+}
+
+func GetMutedWords(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+	words := MutedWords(userID)
+	if words == nil {
+		words = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(words)
+}
+
+// MuteWord hides posts containing the word from the caller's feed.
+func MuteWord(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request MuteRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	word := normalizePattern(request.Word)
+	if word == "" || len([]rune(word)) > MaxPatternLength {
+		http.Error(w, fmt.Sprintf("Word must be between 1 and %d characters", MaxPatternLength), http.StatusBadRequest)
+		return
+	}
+
+	var count int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM muted_words WHERE user_id = ?", userID).Scan(&count); err != nil {
+		logger.LogError("Error counting muted words", err)
+		http.Error(w, "Error muting word", http.StatusInternalServerError)
+		return
+	}
+	if count >= MaxMutedWords {
+		http.Error(w, fmt.Sprintf("You can mute at most %d words", MaxMutedWords), http.StatusBadRequest)
+		return
+	}
+	_, err := db.DB.Exec("INSERT OR IGNORE INTO muted_words (user_id, word, created_at) VALUES (?, ?, ?)", userID, word, time.Now())
+	if err != nil {
+		logger.LogError("Error muting word", err)
+		http.Error(w, "Error muting word", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Word muted", "word": word})
+}
+
+func UnmuteWord(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request MuteRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	_, err := db.DB.Exec("DELETE FROM muted_words WHERE user_id = ? AND word = ?", userID, normalizePattern(request.Word))
+	if err != nil {
+		logger.LogError("Error unmuting word", err)
+		http.Error(w, "Error unmuting word", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Word unmuted"})
+}
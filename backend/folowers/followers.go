@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"social-net/db"
 	logger "social-net/log"
@@ -117,7 +118,11 @@ func SendJSON(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Error generating follow ID", http.StatusInternalServerError)
 			return
 		}
-		_, err := db.DB.Exec(`INSERT INTO Followers (id,follower_id, followed_id, status) VALUES (?,?, ?, ?)`, followID, followerID, followedID, status)
+		var acceptedAt interface{}
+		if status == "accepted" {
+			acceptedAt = time.Now()
+		}
+		_, err := db.DB.Exec(`INSERT INTO Followers (id,follower_id, followed_id, status, accepted_at) VALUES (?,?, ?, ?, ?)`, followID, followerID, followedID, status, acceptedAt)
 		if err != nil {
 			logger.LogError("Error following user", err)
 			http.Error(w, "Error following user", http.StatusInternalServerError)
@@ -1,20 +1,30 @@
 package groups
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
+	"social-net/attachments"
 	"social-net/db"
+	"social-net/filters"
+	"social-net/imaging"
 	logger "social-net/log"
+	"social-net/moderation"
 	"social-net/notification"
+	"social-net/pagination"
+	"social-net/polls"
+	"social-net/reactions"
+	"social-net/richtext"
+	"social-net/tags"
+	"social-net/warnings"
 
 	"social-net/session"
 
@@ -36,15 +46,24 @@ type GroupMember struct {
 }
 
 type GroupPost struct {
-	ID           string    `json:"id"`
-	Title        string    `json:"title"`
-	GroupID      string    `json:"group_id"`
-	UserID       string    `json:"user_id"`
-	Author       string    `json:"author"`
-	Content      string    `json:"content"`
-	Image        string    `json:"image"`
-	CreationDate time.Time `json:"creation_date"`
-	Avatar       string    `json:"avatar"`
+	ID             string                   `json:"id"`
+	Title          string                   `json:"title"`
+	GroupID        string                   `json:"group_id"`
+	UserID         string                   `json:"user_id"`
+	Author         string                   `json:"author"`
+	Content        string                   `json:"content"`
+	ContentHTML    string                   `json:"content_html"`
+	Image          string                   `json:"image"`
+	CreationDate   time.Time                `json:"creation_date"`
+	Avatar         string                   `json:"avatar"`
+	Reactions      map[string]int           `json:"reactions"`
+	MyReaction     string                   `json:"my_reaction"`
+	Attachments    []attachments.Attachment `json:"attachments"`
+	Poll           *polls.Poll              `json:"poll"`
+	ContentWarning string                   `json:"content_warning"`
+	Sensitive      bool                     `json:"sensitive"`
+	Collapsed      bool                     `json:"collapsed"`
+	MediaHidden    bool                     `json:"media_hidden"`
 }
 
 func CreateGroup(w http.ResponseWriter, r *http.Request) {
@@ -487,6 +506,13 @@ func GetGroups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, afterArgs := page.WhereID("g.id")
+
 	query := `
 		SELECT 
 			g.id, 
@@ -496,10 +522,13 @@ func GetGroups(w http.ResponseWriter, r *http.Request) {
 			COALESCE(gm.status, 'not_member') as member_status,
 			COALESCE(gm.is_admin, '0') as is_admin
 		FROM groups g
-		LEFT JOIN group_members gm ON g.id = gm.group_id AND gm.user_id = $1
-		ORDER BY g.id DESC`
+		LEFT JOIN group_members gm ON g.id = gm.group_id AND gm.user_id = ?
+		WHERE ` + after + `
+		ORDER BY g.id DESC
+		LIMIT ?`
 
-	rows, err := db.DB.Query(query, currentUserID)
+	args := append([]interface{}{currentUserID}, afterArgs...)
+	rows, err := db.DB.Query(query, append(args, page.Fetch())...)
 	if err != nil {
 		http.Error(w, "Failed to fetch groups", http.StatusInternalServerError)
 		logger.LogError("Failed to fetch groups", err)
@@ -514,7 +543,13 @@ func GetGroups(w http.ResponseWriter, r *http.Request) {
 	}
 
 	groups := []GroupWithStatus{}
+	var scanned int
+	var last pagination.Cursor
 	for rows.Next() {
+		scanned++
+		if scanned > page.Limit {
+			break
+		}
 		var group GroupWithStatus
 		var ownerID string
 		err := rows.Scan(&group.ID, &ownerID, &group.Title, &group.Description, &group.MemberStatus, &group.IsOwner)
@@ -522,12 +557,16 @@ func GetGroups(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to scan group", http.StatusInternalServerError)
 			return
 		}
+		last.ID = group.ID
 		group.CreatorID, _ = session.GetUsernameFromUserID(ownerID)
 		groups = append(groups, group)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(groups)
+	json.NewEncoder(w).Encode(pagination.Response{
+		Items:      groups,
+		NextCursor: page.Next(scanned, last),
+	})
 }
 
 func MyGroups(w http.ResponseWriter, r *http.Request) {
@@ -996,8 +1035,26 @@ func AddGroupPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var post GroupPost
-	err := json.NewDecoder(r.Body).Decode(&post)
-	if err != nil {
+	var poll *polls.Request
+	multipartForm := strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
+	if multipartForm {
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			log.Println("[AddGroupPost] Error parsing form:", err)
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+		post.Title = r.FormValue("title")
+		post.Content = r.FormValue("content")
+		var err error
+		if post.ContentWarning, post.Sensitive, err = warnings.FromForm(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if poll, err = polls.FromForm(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
 		log.Println("[AddGroupPost] Error decoding request body:", err)
 		http.Error(w, "Failed to decode request body", http.StatusBadRequest)
 		return
@@ -1021,6 +1078,11 @@ func AddGroupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	verdict, ok := filters.Apply(w, groupID, &post.Title, &post.Content, &post.ContentWarning)
+	if !ok {
+		return
+	}
+
 	post_id, err := uuid.NewV7()
 	if err != nil {
 		log.Println("Failed to generate UUID:", err)
@@ -1028,77 +1090,139 @@ func AddGroupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var imagePath string
-	if post.Image != "" {
-		imagePath, err = saveBase64Image(post.Image, post_id.String())
+	var uploads []attachments.Upload
+	if multipartForm {
+		var ok bool
+		uploads, ok = attachments.SaveUploads(w, r, "group_post_"+post_id.String())
+		if !ok {
+			return
+		}
+		if len(uploads) > 0 {
+			imagePath = uploads[0].Filename
+		}
+	} else if post.Image != "" {
+		stored, err := saveBase64Image(post.Image, post_id.String())
+		if err == imaging.ErrInvalid || err == imaging.ErrTooLarge || err == errImageTooLarge {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			log.Println("[AddGroupPost] Error saving image:", err)
 			http.Error(w, "Failed to save image", http.StatusInternalServerError)
 			return
 		}
+		imagePath = stored.Filename
+		uploads = []attachments.Upload{{Filename: imagePath, Width: stored.Width, Height: stored.Height}}
 	}
+	committed := false
+	defer func() {
+		if !committed {
+			attachments.Discard(uploads)
+		}
+	}()
 
-	_, err = db.DB.Exec(`
-		INSERT INTO group_posts (id, group_id, user_id, title, content, creation_date,image)
-		VALUES ($1, $2, $3, $4, $5, $6,$7)
-	`, post_id.String(), groupID, userID, post.Title, post.Content, time.Now().UTC(), imagePath)
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Println("[AddGroupPost] Error starting transaction:", err)
+		http.Error(w, "Failed to insert post into database", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO group_posts (id, group_id, user_id, title, content, creation_date,image, content_warning, sensitive)
+		VALUES ($1, $2, $3, $4, $5, $6,$7, $8, $9)
+	`, post_id.String(), groupID, userID, post.Title, post.Content, time.Now().UTC(), imagePath, post.ContentWarning, post.Sensitive)
 	if err != nil {
 		log.Println("[AddGroupPost] Error inserting post into database:", err)
 		http.Error(w, "Failed to insert post into database", http.StatusInternalServerError)
 		return
 	}
 
+	if err := attachments.Insert(tx, attachments.TargetGroupPost, post_id.String(), uploads); err != nil {
+		log.Println("[AddGroupPost] Error inserting attachments:", err)
+		http.Error(w, "Failed to insert post into database", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tags.Index(tx, tags.TargetGroupPost, post_id.String(), post.Content); err != nil {
+		log.Println("[AddGroupPost] Error indexing tags:", err)
+		http.Error(w, "Failed to insert post into database", http.StatusInternalServerError)
+		return
+	}
+
+	if err := polls.Create(tx, polls.TargetGroupPost, post_id.String(), userID, poll); err != nil {
+		log.Println("[AddGroupPost] Error inserting poll:", err)
+		http.Error(w, "Failed to insert post into database", http.StatusInternalServerError)
+		return
+	}
+
+	if err := verdict.Flag(tx, moderation.TargetGroupPost, post_id.String(), groupID); err != nil {
+		log.Println("[AddGroupPost] Error flagging post:", err)
+		http.Error(w, "Failed to insert post into database", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("[AddGroupPost] Error committing transaction:", err)
+		http.Error(w, "Failed to insert post into database", http.StatusInternalServerError)
+		return
+	}
+	committed = true
+
 	w.WriteHeader(http.StatusCreated)
 }
 
-func saveBase64Image(base64Data, postID string) (string, error) {
-	if !strings.Contains(base64Data, ",") {
-		return "", fmt.Errorf("invalid base64 image format")
-	}
+var errImageTooLarge = errors.New("image file too large")
 
+// saveBase64Image stores an image sent as a data URL. The format is taken
+// from the decoded image rather than the header.
+func saveBase64Image(base64Data, postID string) (imaging.Variant, error) {
 	parts := strings.Split(base64Data, ",")
 	if len(parts) != 2 {
-		return "", fmt.Errorf("invalid base64 image format")
-	}
-
-	header := parts[0]
-	var ext string
-	if strings.Contains(header, "jpeg") || strings.Contains(header, "jpg") {
-		ext = ".jpg"
-	} else if strings.Contains(header, "png") {
-		ext = ".png"
-	} else if strings.Contains(header, "gif") {
-		ext = ".gif"
-	} else if strings.Contains(header, "webp") {
-		ext = ".webp"
-	} else {
-		ext = ".jpg"
+		return imaging.Variant{}, imaging.ErrInvalid
 	}
-
 	imageData, err := base64.StdEncoding.DecodeString(parts[1])
 	if err != nil {
-		return "", fmt.Errorf("failed to decode base64 image: %v", err)
+		return imaging.Variant{}, imaging.ErrInvalid
 	}
-
-	uploadsDir := "./uploads"
-	if err := os.MkdirAll(uploadsDir, 0o755); err != nil {
-		return "", fmt.Errorf("failed to create uploads directory: %v", err)
+	if len(imageData) > attachments.MaxFileSize {
+		return imaging.Variant{}, errImageTooLarge
 	}
+	return imaging.Store(bytes.NewReader(imageData), fmt.Sprintf("group_post_%s_%d", postID, time.Now().Unix()))
+}
 
-	filename := fmt.Sprintf("group_post_%s_%d%s", postID, time.Now().Unix(), ext)
-	filePath := filepath.Join(uploadsDir, filename)
-
-	file, err := os.Create(filePath)
+func CheckUserGroupPostPermission(userID string, postID string) bool {
+	var exists bool
+	err := db.DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM group_posts p
+			JOIN group_members gm ON gm.group_id = p.group_id
+			WHERE p.id = $1 AND gm.user_id = $2 AND gm.status = 'accepted'
+			AND (p.user_id = $2 OR `+moderation.Visible(moderation.TargetGroupPost, "p.id")+`)
+		)`, postID, userID).Scan(&exists)
 	if err != nil {
-		return "", fmt.Errorf("failed to create image file: %v", err)
+		log.Println("Error checking group post permission:", err)
+		return false
 	}
-	defer file.Close()
+	return exists
+}
 
-	_, err = file.Write(imageData)
-	if err != nil {
-		return "", fmt.Errorf("failed to write image data: %v", err)
+// CanModerateGroupPost reports whether userID may remove others' comments on
+// a group post: its author and the group's admins can.
+func CanModerateGroupPost(userID string, postID string) bool {
+	var ownerID, groupID string
+	if err := db.DB.QueryRow("SELECT user_id, group_id FROM group_posts WHERE id = ?", postID).Scan(&ownerID, &groupID); err != nil {
+		return false
 	}
+	return ownerID == userID || moderation.IsGroupAdmin(userID, groupID)
+}
 
-	return filename, nil
+// GroupOfPost returns the group a group post was made in.
+func GroupOfPost(postID string) (string, error) {
+	var groupID string
+	err := db.DB.QueryRow("SELECT group_id FROM group_posts WHERE id = ?", postID).Scan(&groupID)
+	return groupID, err
 }
 
 func GetGroupPosts(w http.ResponseWriter, r *http.Request) {
@@ -1112,12 +1236,31 @@ func GetGroupPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
 	groupID := r.URL.Query().Get("group_id")
 	if groupID == "" {
 		http.Error(w, "Group ID is required", http.StatusBadRequest)
 		return
 	}
 
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, afterArgs := page.Where("p.creation_date", "p.id")
+
+	args := append([]interface{}{groupID, userID}, afterArgs...)
 	rows, err := db.DB.Query(`
 		SELECT DISTINCT 
 			p.id, 
@@ -1127,12 +1270,16 @@ func GetGroupPosts(w http.ResponseWriter, r *http.Request) {
 			p.content, 
 			p.creation_date, 
 			u.avatar,
-			p.image
+			p.image,
+			p.content_warning,
+			p.sensitive,
+			CAST(p.creation_date AS TEXT)
 		FROM group_posts p
 		JOIN users u ON p.user_id = u.id
-		WHERE p.group_id = $1
-		ORDER BY p.creation_date DESC
-	`, groupID)
+		WHERE p.group_id = ? AND (p.user_id = ? OR `+moderation.Visible(moderation.TargetGroupPost, "p.id")+`) AND `+after+`
+		ORDER BY p.creation_date DESC, p.id DESC
+		LIMIT ?
+	`, append(args, page.Fetch())...)
 	if err != nil {
 		log.Println("[GetGroupPosts] DB query error:", err)
 		http.Error(w, "Failed to get posts from database", http.StatusInternalServerError)
@@ -1141,7 +1288,13 @@ func GetGroupPosts(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	posts := make([]GroupPost, 0)
+	var scanned int
+	var last pagination.Cursor
 	for rows.Next() {
+		scanned++
+		if scanned > page.Limit {
+			break
+		}
 		var post GroupPost
 		var imageFilename sql.NullString
 		err := rows.Scan(
@@ -1153,24 +1306,79 @@ func GetGroupPosts(w http.ResponseWriter, r *http.Request) {
 			&post.CreationDate,
 			&post.Avatar,
 			&imageFilename,
+			&post.ContentWarning,
+			&post.Sensitive,
+			&last.Time,
 		)
 		if err != nil {
 			log.Println("[GetGroupPosts] Row scan error:", err)
 			http.Error(w, "Failed to scan post row", http.StatusInternalServerError)
 			return
 		}
-		if imageFilename.Valid && imageFilename.String != "" {
-			post.Image = fmt.Sprintf("http://20.56.138.63:8080/uploads/%s", imageFilename.String)
-		}
+		last.ID = post.ID
+		fillGroupPost(userID, &post, imageFilename)
 		posts = append(posts, post)
 	}
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(posts); err != nil {
+	if err := json.NewEncoder(w).Encode(pagination.Response{
+		Items:      posts,
+		NextCursor: page.Next(scanned, last),
+	}); err != nil {
 		log.Println("[GetGroupPosts] JSON encode error:", err)
 		http.Error(w, "Failed to encode posts as JSON", http.StatusInternalServerError)
 	}
 }
 
+func fillGroupPost(userID string, post *GroupPost, imageFilename sql.NullString) {
+	var err error
+	post.ContentHTML = richtext.Render(post.Content)
+	post.Collapsed, post.MediaHidden = warnings.Apply(warnings.Preference(userID), post.ContentWarning, post.Sensitive)
+	post.Reactions, post.MyReaction, err = reactions.Summary(userID, reactions.TargetGroupPost, post.ID)
+	if err != nil {
+		log.Println("[GetGroupPosts] Reaction count error:", err)
+	}
+	post.Attachments = []attachments.Attachment{}
+	if post.MediaHidden {
+		post.Image = ""
+	} else {
+		if imageFilename.Valid && imageFilename.String != "" {
+			post.Image = imaging.URL(imageFilename.String, imaging.SizeMedium)
+		}
+		post.Attachments, err = attachments.List(attachments.TargetGroupPost, post.ID)
+		if err != nil {
+			log.Println("[GetGroupPosts] Attachment fetch error:", err)
+		}
+	}
+	post.Poll, err = polls.ForTarget(userID, polls.TargetGroupPost, post.ID)
+	if err != nil {
+		log.Println("[GetGroupPosts] Poll fetch error:", err)
+	}
+}
+
+// LoadGroupPost returns a single group post as GetGroupPosts would show it,
+// or false when it doesn't exist or userID isn't a member of its group.
+func LoadGroupPost(userID, postID string) (*GroupPost, bool) {
+	if !CheckUserGroupPostPermission(userID, postID) {
+		return nil, false
+	}
+	var post GroupPost
+	var imageFilename sql.NullString
+	err := db.DB.QueryRow(`
+		SELECT p.id, p.group_id, p.user_id, u.username, p.title, p.content, p.creation_date, u.avatar, p.image, p.content_warning, p.sensitive
+		FROM group_posts p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.id = ?
+	`, postID).Scan(&post.ID, &post.GroupID, &post.UserID, &post.Author, &post.Title, &post.Content, &post.CreationDate, &post.Avatar, &imageFilename, &post.ContentWarning, &post.Sensitive)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("[LoadGroupPost] DB query error:", err)
+		}
+		return nil, false
+	}
+	fillGroupPost(userID, &post, imageFilename)
+	return &post, true
+}
+
 func GetUserPendingInvitations(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
@@ -0,0 +1,62 @@
+package imaging
+
+import "encoding/binary"
+
+// MaxFrames caps how many frames an animated GIF may have.
+const MaxFrames = 200
+
+// gifFrames walks the blocks of a GIF without decoding any image data and
+// returns how many frames it has and how many pixels they add up to. The
+// header check in Process only sees the canvas, while decoding allocates
+// every frame.
+func gifFrames(data []byte) (frames, pixels int, ok bool) {
+	if len(data) < 13 {
+		return 0, 0, false
+	}
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << ((flags & 0x07) + 1)
+	}
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B: // trailer
+			return frames, pixels, true
+		case 0x21: // extension: label, then sub-blocks
+			if i += 2; i > len(data) {
+				return 0, 0, false
+			}
+		case 0x2C: // image descriptor
+			if i+10 > len(data) {
+				return 0, 0, false
+			}
+			w := int(binary.LittleEndian.Uint16(data[i+5:]))
+			h := int(binary.LittleEndian.Uint16(data[i+7:]))
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << ((flags & 0x07) + 1)
+			}
+			// LZW minimum code size, then sub-blocks
+			i++
+			frames++
+			pixels += w * h
+			if frames > MaxFrames || pixels > MaxPixels {
+				return frames, pixels, true
+			}
+		default:
+			return 0, 0, false
+		}
+		for {
+			if i >= len(data) {
+				return 0, 0, false
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+	}
+	// the decoder accepts files that end without a trailer
+	return frames, pixels, true
+}
@@ -0,0 +1,255 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"social-net/db"
+)
+
+// Sizes every uploaded image is stored in. The original keeps its
+// dimensions; the others are scaled down to fit their longest edge.
+const (
+	SizeOriginal = "original"
+	SizeMedium   = "medium"
+	SizeThumb    = "thumb"
+
+	MediumEdge = 1280
+	ThumbEdge  = 320
+
+	// MaxPixels rejects small files that would decode into huge images. For
+	// an animated GIF it bounds all frames together.
+	MaxPixels   = 40_000_000
+	JPEGQuality = 85
+)
+
+const (
+	UploadsDir = "./uploads"
+	BaseURL    = "http://20.56.138.63:8080/uploads/"
+)
+
+var (
+	ErrInvalid  = errors.New("Invalid image file type")
+	ErrTooLarge = errors.New("Image dimensions are too large")
+)
+
+var edges = map[string]int{
+	SizeMedium: MediumEdge,
+	SizeThumb:  ThumbEdge,
+}
+
+var extensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+}
+
+// Encoded is one size of a processed image, re-encoded without any of the
+// metadata the upload carried.
+type Encoded struct {
+	Size   string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// Variant is one stored size of an image.
+type Variant struct {
+	Filename string `json:"-"`
+	URL      string `json:"url"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+// Process decodes a JPEG, PNG or GIF, turns it upright according to its EXIF
+// orientation and re-encodes it in every size. It returns the file extension
+// for the format along with the encoded sizes.
+func Process(data []byte) (string, []Encoded, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, ErrInvalid
+	}
+	ext, ok := extensions[format]
+	if !ok {
+		return "", nil, ErrInvalid
+	}
+	if config.Width*config.Height > MaxPixels {
+		return "", nil, ErrTooLarge
+	}
+
+	var src image.Image
+	var animation *gif.GIF
+	orientation := 1
+	switch format {
+	case "jpeg":
+		src, err = jpeg.Decode(bytes.NewReader(data))
+		orientation = jpegOrientation(data)
+	case "png":
+		src, err = png.Decode(bytes.NewReader(data))
+	case "gif":
+		frames, pixels, ok := gifFrames(data)
+		if !ok {
+			return "", nil, ErrInvalid
+		}
+		if frames > MaxFrames || pixels > MaxPixels {
+			return "", nil, ErrTooLarge
+		}
+		animation, err = gif.DecodeAll(bytes.NewReader(data))
+		if err == nil {
+			// frames can be smaller than the canvas they are drawn on
+			canvas := image.NewRGBA(image.Rect(0, 0, animation.Config.Width, animation.Config.Height))
+			frame := animation.Image[0]
+			draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+			src = canvas
+		}
+	}
+	if err != nil {
+		return "", nil, ErrInvalid
+	}
+
+	upright := orient(toRGBA(src), orientation)
+	width, height := upright.Bounds().Dx(), upright.Bounds().Dy()
+
+	var original bytes.Buffer
+	if animation != nil && len(animation.Image) > 1 {
+		// the encoder only writes frames and timing, so comments and
+		// application extensions are dropped with the rest
+		err = gif.EncodeAll(&original, animation)
+	} else {
+		err = encode(&original, format, upright)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	sizes := []Encoded{{Size: SizeOriginal, Width: width, Height: height, Data: original.Bytes()}}
+
+	for _, size := range []string{SizeMedium, SizeThumb} {
+		w, h := fit(width, height, edges[size])
+		var buf bytes.Buffer
+		if err := encode(&buf, format, shrink(upright, w, h)); err != nil {
+			return "", nil, err
+		}
+		sizes = append(sizes, Encoded{Size: size, Width: w, Height: h, Data: buf.Bytes()})
+	}
+	return ext, sizes, nil
+}
+
+func encode(w io.Writer, format string, img image.Image) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: JPEGQuality})
+	case "gif":
+		return gif.Encode(w, img, &gif.Options{NumColors: 256})
+	}
+	return png.Encode(w, img)
+}
+
+// Store processes an upload and writes it with Write.
+func Store(r io.Reader, base string) (Variant, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Variant{}, err
+	}
+	ext, sizes, err := Process(data)
+	if err != nil {
+		return Variant{}, err
+	}
+	return Write(base, ext, sizes)
+}
+
+// Write saves every size of a processed image to ./uploads as base plus a
+// suffix and the extension, and records their dimensions. It returns the
+// original, whose file name is what callers keep.
+func Write(base, ext string, sizes []Encoded) (Variant, error) {
+	if err := os.MkdirAll(UploadsDir, os.ModePerm); err != nil {
+		return Variant{}, err
+	}
+
+	filename := base + ext
+	var written []string
+	for _, size := range sizes {
+		name := filename
+		if size.Size != SizeOriginal {
+			name = base + "_" + size.Size + ext
+		}
+		err := os.WriteFile(filepath.Join(UploadsDir, name), size.Data, 0o644)
+		if err == nil {
+			written = append(written, name)
+			_, err = db.DB.Exec(`
+				INSERT OR REPLACE INTO image_variants (filename, size, variant, width, height, created_at)
+				VALUES (?, ?, ?, ?, ?, ?)
+			`, filename, size.Size, name, size.Width, size.Height, time.Now())
+		}
+		if err != nil {
+			for _, name := range written {
+				os.Remove(filepath.Join(UploadsDir, name))
+			}
+			db.DB.Exec("DELETE FROM image_variants WHERE filename = ?", filename)
+			return Variant{}, err
+		}
+	}
+	return Variant{Filename: filename, URL: BaseURL + filename, Width: sizes[0].Width, Height: sizes[0].Height}, nil
+}
+
+// Remove deletes every stored size of an image and their records, for
+// uploads whose post or comment was never saved or has been deleted.
+func Remove(filename string) {
+	if filename == "" {
+		return
+	}
+	variants, err := Variants(filename)
+	if err != nil {
+		log.Println("Failed to list image sizes:", err)
+	}
+	for _, variant := range variants {
+		os.Remove(filepath.Join(UploadsDir, variant.Filename))
+	}
+	os.Remove(filepath.Join(UploadsDir, filename))
+	if _, err := db.DB.Exec("DELETE FROM image_variants WHERE filename = ?", filename); err != nil {
+		log.Println("Failed to remove image sizes:", err)
+	}
+}
+
+// URL returns the address of an image in the given size. Images uploaded
+// before sizes were stored only have their original.
+func URL(filename, size string) string {
+	if filename == "" {
+		return ""
+	}
+	var variant string
+	err := db.DB.QueryRow("SELECT variant FROM image_variants WHERE filename = ? AND size = ?", filename, size).Scan(&variant)
+	if err != nil {
+		variant = filename
+	}
+	return BaseURL + variant
+}
+
+// Variants lists the stored sizes of an image by size name.
+func Variants(filename string) (map[string]Variant, error) {
+	rows, err := db.DB.Query("SELECT size, variant, width, height FROM image_variants WHERE filename = ?", filename)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	variants := map[string]Variant{}
+	for rows.Next() {
+		var size string
+		var v Variant
+		if err := rows.Scan(&size, &v.Filename, &v.Width, &v.Height); err != nil {
+			return nil, err
+		}
+		v.URL = BaseURL + v.Filename
+		variants[size] = v
+	}
+	return variants, rows.Err()
+}
@@ -0,0 +1,192 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+)
+
+// rawGIF builds an animated GIF of n w×h frames without encoding any pixels,
+// the way a decompression bomb would: each frame's data is a single clear
+// code, so the file stays tiny whatever the frame size.
+func rawGIF(n, w, h int) []byte {
+	var b bytes.Buffer
+	b.WriteString("GIF89a")
+	binary.Write(&b, binary.LittleEndian, uint16(w))
+	binary.Write(&b, binary.LittleEndian, uint16(h))
+	b.Write([]byte{0x80, 0, 0}) // 2-colour global table
+	b.Write([]byte{0, 0, 0, 255, 255, 255})
+	b.Write([]byte{0x21, 0xF9, 4, 0, 10, 0, 0, 0}) // graphic control
+	for i := 0; i < n; i++ {
+		b.Write([]byte{0x2C, 0, 0, 0, 0})
+		binary.Write(&b, binary.LittleEndian, uint16(w))
+		binary.Write(&b, binary.LittleEndian, uint16(h))
+		b.Write([]byte{0, 2, 1, 0x44, 0})
+	}
+	b.WriteByte(0x3B)
+	return b.Bytes()
+}
+
+func TestProcessRejectsGIFBombs(t *testing.T) {
+	tests := []struct {
+		name         string
+		frames, w, h int
+	}{
+		{"many large frames", 10, 6000, 6000},
+		{"just over the pixel budget", 11, 2000, 2000},
+		{"too many frames", MaxFrames + 1, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Process(rawGIF(tt.frames, tt.w, tt.h))
+			if err != ErrTooLarge {
+				t.Fatalf("Process() error = %v, want ErrTooLarge", err)
+			}
+		})
+	}
+}
+
+func TestProcessKeepsSmallAnimations(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 40, 20), palette)
+		frame.SetColorIndex(i, i, 1)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	ext, sizes, err := Process(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if ext != ".gif" || len(sizes) != 3 {
+		t.Fatalf("Process() = %q with %d sizes, want .gif with 3", ext, len(sizes))
+	}
+	out, err := gif.DecodeAll(bytes.NewReader(sizes[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Image) != 3 {
+		t.Errorf("original has %d frames, want 3", len(out.Image))
+	}
+}
+
+func TestGIFFramesRejectsTruncatedFiles(t *testing.T) {
+	data := rawGIF(2, 10, 10)
+	if _, _, ok := gifFrames(data[:20]); ok {
+		t.Error("gifFrames accepted a truncated file")
+	}
+}
+
+// withExif inserts an APP1 segment right after the JPEG's SOI marker holding
+// an orientation tag and a GPS IFD with a latitude reference.
+func withExif(jpg []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	le := binary.LittleEndian
+	tiff.WriteString("II")
+	binary.Write(&tiff, le, uint16(42))
+	binary.Write(&tiff, le, uint32(8))
+	// IFD0: orientation and a pointer to the GPS IFD right after it
+	binary.Write(&tiff, le, uint16(2))
+	binary.Write(&tiff, le, []uint16{0x0112, 3})
+	binary.Write(&tiff, le, uint32(1))
+	binary.Write(&tiff, le, []uint16{orientation, 0})
+	binary.Write(&tiff, le, []uint16{0x8825, 4})
+	binary.Write(&tiff, le, []uint32{1, 8 + 2 + 2*12 + 4})
+	binary.Write(&tiff, le, uint32(0))
+	// GPS IFD: GPSLatitudeRef "N"
+	binary.Write(&tiff, le, uint16(1))
+	binary.Write(&tiff, le, []uint16{0x0001, 2})
+	binary.Write(&tiff, le, uint32(2))
+	tiff.Write([]byte{'N', 0, 0, 0})
+	binary.Write(&tiff, le, uint32(0))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(jpg[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(jpg[2:])
+	return out.Bytes()
+}
+
+// testJPEG encodes a w×h JPEG that is red on its left half and blue on its
+// right.
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessStripsExif(t *testing.T) {
+	data := withExif(testJPEG(t, 1600, 800), 1)
+	if !bytes.Contains(data, []byte("Exif\x00\x00")) {
+		t.Fatal("test JPEG is missing its EXIF segment")
+	}
+
+	_, sizes, err := Process(data)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if len(sizes) != 3 {
+		t.Fatalf("Process() returned %d sizes, want 3", len(sizes))
+	}
+	for _, size := range sizes {
+		if bytes.Contains(size.Data, []byte("Exif\x00\x00")) || bytes.Contains(size.Data, []byte{0xFF, 0xE1}) {
+			t.Errorf("%s variant still carries an EXIF segment", size.Size)
+		}
+	}
+}
+
+func TestProcessAppliesOrientation(t *testing.T) {
+	// orientation 6: the camera was turned a quarter clockwise, so the stored
+	// 1600×800 picture shows upright as 800×1600
+	data := withExif(testJPEG(t, 1600, 800), 6)
+	if jpegOrientation(data) != 6 {
+		t.Fatalf("test JPEG has orientation %d, want 6", jpegOrientation(data))
+	}
+	_, sizes, err := Process(data)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	for _, size := range sizes {
+		img, err := jpeg.Decode(bytes.NewReader(size.Data))
+		if err != nil {
+			t.Fatalf("%s variant: %v", size.Size, err)
+		}
+		w, h := img.Bounds().Dx(), img.Bounds().Dy()
+		if w >= h || w != size.Width || h != size.Height {
+			t.Errorf("%s variant is %d×%d (reported %d×%d), want it portrait", size.Size, w, h, size.Width, size.Height)
+		}
+		// the red left half ends up on top
+		if r, _, b, _ := img.At(w/2, h/4).RGBA(); r < b {
+			t.Errorf("%s variant has blue on top, want red", size.Size)
+		}
+	}
+	if sizes[0].Width != 800 || sizes[0].Height != 1600 {
+		t.Errorf("original is %d×%d, want 800×1600", sizes[0].Width, sizes[0].Height)
+	}
+}
@@ -6,19 +6,31 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"social-net/audiences"
 	"social-net/auth"
+	"social-net/bookmarks"
 	"social-net/comments"
 	"social-net/db"
 	"social-net/events"
+	"social-net/filters"
 	"social-net/folowers"
 	"social-net/groups"
+	"social-net/mentions"
 	"social-net/messages"
+	"social-net/moderation"
 	"social-net/notification"
+	"social-net/polls"
 	"social-net/posts"
+	"social-net/previews"
 	"social-net/profile"
+	"social-net/reactions"
 	"social-net/session"
+	"social-net/tags"
 	"social-net/utils"
+	"social-net/views"
+	"social-net/warnings"
 )
 
 func main() {
@@ -28,6 +40,56 @@ func main() {
 
 	db.Initdb()
 
+	comments.RegisterTarget(comments.TargetPost, comments.Target{
+		CanView:     posts.CheckUserPostPermission,
+		CanComment:  posts.CanComment,
+		CanModerate: posts.IsPostOwner,
+	})
+	comments.RegisterTarget(comments.TargetGroupPost, comments.Target{
+		CanView:     groups.CheckUserGroupPostPermission,
+		CanModerate: groups.CanModerateGroupPost,
+		Group:       groups.GroupOfPost,
+	})
+	comments.RegisterTarget(comments.TargetEvent, comments.Target{
+		CanView:     events.CheckUserEventPermission,
+		CanModerate: events.CanModerateEvent,
+		Group:       events.GroupOfEvent,
+	})
+	reactions.SetAccessCheck(reactions.TargetPost, posts.CheckUserPostPermission)
+	reactions.SetAccessCheck(reactions.TargetComment, comments.CheckUserCommentPermission)
+	reactions.SetAccessCheck(reactions.TargetGroupPost, groups.CheckUserGroupPostPermission)
+	tags.SetAccessCheck(tags.TargetPost, posts.CheckUserPostPermission)
+	tags.SetAccessCheck(tags.TargetGroupPost, groups.CheckUserGroupPostPermission)
+	mentions.SetAccessCheck(mentions.TargetPost, posts.CheckUserPostPermission)
+	mentions.SetAccessCheck(mentions.TargetComment, comments.CheckUserCommentPermission)
+	mentions.SetAccessCheck(mentions.TargetMessage, messages.CheckUserMessagePermission)
+	mentions.SetAccessCheck(mentions.TargetGroupMessage, messages.CheckUserGroupMessagePermission)
+	polls.SetAccessCheck(polls.TargetPost, posts.CheckUserPostPermission)
+	polls.SetAccessCheck(polls.TargetGroupPost, groups.CheckUserGroupPostPermission)
+	notification.SetAccessCheck(notification.EntityPost, posts.CheckUserPostPermission)
+	notification.SetAccessCheck(notification.EntityComment, comments.CheckUserCommentPermission)
+	notification.SetAccessCheck(notification.EntityGroupPost, groups.CheckUserGroupPostPermission)
+	notification.SetAccessCheck(notification.EntityMessage, messages.CheckUserMessagePermission)
+	notification.SetAccessCheck(notification.EntityGroupMessage, messages.CheckUserGroupMessagePermission)
+	moderation.SetAccessCheck(moderation.TargetPost, posts.CheckUserPostPermission)
+	moderation.SetAccessCheck(moderation.TargetComment, comments.CheckUserCommentPermission)
+	moderation.SetAccessCheck(moderation.TargetGroupPost, groups.CheckUserGroupPostPermission)
+	moderation.SetAccessCheck(moderation.TargetMessage, messages.CheckUserMessagePermission)
+	moderation.SetAccessCheck(moderation.TargetGroupMessage, messages.CheckUserGroupMessagePermission)
+	moderation.SetAutoHideThreshold(moderation.DefaultAutoHideThreshold)
+	bookmarks.SetLoader(bookmarks.TargetPost, func(userID, postID string) (interface{}, bool) {
+		return posts.LoadPost(userID, postID)
+	})
+	bookmarks.SetLoader(bookmarks.TargetGroupPost, func(userID, postID string) (interface{}, bool) {
+		return groups.LoadGroupPost(userID, postID)
+	})
+	posts.OnPublish(tags.IndexPost)
+	posts.OnPublish(mentions.RecordPost)
+	posts.OnPublish(previews.WarmPost)
+	posts.StartScheduler(30 * time.Second)
+	tags.StartTrendingJob(5 * time.Minute)
+	views.StartFlusher(10 * time.Second)
+
 	http.HandleFunc("/api/auth/", auth.Auth)
 	http.HandleFunc("/middle", session.Middleware)
 	http.HandleFunc("/api/info", auth.Getinfo)
@@ -41,13 +103,37 @@ func main() {
 	http.HandleFunc("/api/getfollowingfolowers", profile.GetFollowersAndFollowing)
 	http.HandleFunc("/api/postsprivacy", profile.GetFollowersAndFollowingPosts)
 	http.HandleFunc("/api/checkmyprivacy", profile.CheckMyPrivacy)
+	http.HandleFunc("/api/preferences/sensitive", warnings.SensitiveMediaPreference)
 	http.HandleFunc("/api/getinvitationsfollow", profile.GetInvitationsFollow)
 	http.HandleFunc("/api/accepteinvi", profile.AcceptInvitation)
 
 	http.HandleFunc("/api/posts", posts.Post)
 	http.HandleFunc("/api/getposts", posts.Getposts)
+	http.HandleFunc("/api/posts/edit", posts.EditPost)
+	http.HandleFunc("/api/posts/delete", posts.DeletePost)
+	http.HandleFunc("/api/posts/history", posts.GetPostHistory)
+	http.HandleFunc("/api/posts/audience", posts.GetPostAudience)
+	http.HandleFunc("/api/posts/audience/add", posts.AddPostAudience)
+	http.HandleFunc("/api/posts/audience/remove", posts.RemovePostAudience)
+	http.HandleFunc("/api/posts/repost", posts.Repost)
+	http.HandleFunc("/api/posts/warning", posts.SetContentWarning)
+	http.HandleFunc("/api/posts/visibility", posts.SetVisibility)
+	http.HandleFunc("/api/posts/comments", posts.SetCommentControls)
+	http.HandleFunc("/api/audiences", audiences.GetLists)
+	http.HandleFunc("/api/audiences/create", audiences.CreateList)
+	http.HandleFunc("/api/audiences/rename", audiences.RenameList)
+	http.HandleFunc("/api/audiences/delete", audiences.DeleteList)
+	http.HandleFunc("/api/audiences/members/add", audiences.AddMembers)
+	http.HandleFunc("/api/audiences/members/remove", audiences.RemoveMembers)
+	http.HandleFunc("/api/posts/drafts", posts.GetDrafts)
+	http.HandleFunc("/api/posts/drafts/edit", posts.EditDraft)
+	http.HandleFunc("/api/comments", comments.Getcomments)
+	http.HandleFunc("/api/comments/add", comments.AddComments)
 	http.HandleFunc("/api/getcomments", comments.Getcomments)
 	http.HandleFunc("/api/addcomments", comments.AddComments)
+	http.HandleFunc("/api/comments/thread", comments.GetThread)
+	http.HandleFunc("/api/comments/edit", comments.EditComment)
+	http.HandleFunc("/api/comments/delete", comments.DeleteComment)
 
 	http.HandleFunc("/api/getmessages", messages.GetMessages)
 	http.HandleFunc("/api/messages", messages.GetMessages)
@@ -69,8 +155,11 @@ func main() {
 	http.HandleFunc("/api/checkmem", groups.CheckGroupMembershipStatus)
 	http.HandleFunc("/api/acceptgroupinvite", groups.HandleInvitation)
 	http.HandleFunc("/api/declinegroupinvite", groups.HandleInvitation)
-	http.HandleFunc("/api/groupcomments/add", groups.AddGroupComment)
-	http.HandleFunc("/api/groupcomments", groups.GetGroupComments)
+	http.HandleFunc("/api/groupcomments/add", comments.AddComments)
+	http.HandleFunc("/api/groupcomments", comments.Getcomments)
+	http.HandleFunc("/api/groupcomments/thread", comments.GetThread)
+	http.HandleFunc("/api/groupcomments/edit", comments.EditComment)
+	http.HandleFunc("/api/groupcomments/delete", comments.DeleteComment)
 	http.HandleFunc("/api/user/pendinginvites", groups.GetUserPendingInvitations)
 	http.HandleFunc("/api/groupmembers/status", groups.GetGroupMemberStatuses)
 
@@ -87,6 +176,38 @@ func main() {
 	http.HandleFunc("/ws/group/", messages.HandleGroupWebSocket)
 	http.HandleFunc("/ws/notifications", notification.HandleNotificationWebSocket)
 
+	http.HandleFunc("/api/reactions", reactions.GetReactions)
+	http.HandleFunc("/api/reactions/add", reactions.AddReaction)
+	http.HandleFunc("/api/reactions/remove", reactions.RemoveReaction)
+
+	http.HandleFunc("/api/tags/", tags.GetTagFeed)
+	http.HandleFunc("/api/trending", tags.GetTrending)
+
+	http.HandleFunc("/api/polls", polls.GetPoll)
+	http.HandleFunc("/api/polls/vote", polls.Vote)
+
+	http.HandleFunc("/api/bookmarks", bookmarks.GetBookmarks)
+	http.HandleFunc("/api/bookmarks/add", bookmarks.AddBookmark)
+	http.HandleFunc("/api/bookmarks/remove", bookmarks.RemoveBookmark)
+	http.HandleFunc("/api/bookmarks/collections", bookmarks.GetCollections)
+	http.HandleFunc("/api/bookmarks/collections/create", bookmarks.CreateCollection)
+	http.HandleFunc("/api/bookmarks/collections/rename", bookmarks.RenameCollection)
+	http.HandleFunc("/api/bookmarks/collections/delete", bookmarks.DeleteCollection)
+
+	http.HandleFunc("/api/analytics", views.GetProfileAnalytics)
+	http.HandleFunc("/api/analytics/post", views.GetPostAnalytics)
+
+	http.HandleFunc("/api/reports", moderation.CreateReport)
+	http.HandleFunc("/api/reports/queue", moderation.GetQueue)
+	http.HandleFunc("/api/reports/resolve", moderation.ResolveReport)
+
+	http.HandleFunc("/api/filters", filters.GetRules)
+	http.HandleFunc("/api/filters/create", filters.CreateRule)
+	http.HandleFunc("/api/filters/delete", filters.DeleteRule)
+	http.HandleFunc("/api/mutedwords", filters.GetMutedWords)
+	http.HandleFunc("/api/mutedwords/add", filters.MuteWord)
+	http.HandleFunc("/api/mutedwords/remove", filters.UnmuteWord)
+
 	http.HandleFunc("/api/allusers", utils.Users)
 	http.HandleFunc("/api/getavatar", auth.GetAvatar)
 
@@ -104,6 +225,9 @@ func main() {
 
 	sig := <-sigChan
 	fmt.Println("signal err:", sig)
+	if err := views.Flush(); err != nil {
+		fmt.Println("Error flushing post views:", err)
+	}
 	db.DB.Close()
 
 }
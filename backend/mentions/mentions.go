@@ -0,0 +1,226 @@
+package mentions
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/notification"
+	"social-net/session"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	TargetPost         = "post"
+	TargetComment      = "comment"
+	TargetMessage      = "message"
+	TargetGroupMessage = "group_message"
+)
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@/])@([\p{L}\p{N}_.-]+)`)
+
+var targetLabels = map[string]string{
+	TargetPost:         "a post",
+	TargetComment:      "a comment",
+	TargetMessage:      "a message",
+	TargetGroupMessage: "a group chat message",
+}
+
+// Span is one piece of a text. Mentions of existing users carry the username
+// so clients can render a link without parsing the text again.
+type Span struct {
+	Text     string `json:"text"`
+	Username string `json:"username,omitempty"`
+}
+
+type match struct {
+	start, end int
+	username   string
+}
+
+var accessChecks = map[string]func(userID string, targetID string) bool{}
+
+// SetAccessCheck registers the visibility rule for a target type. Mentioned
+// users only get a notification when the check lets them see the content.
+func SetAccessCheck(targetType string, check func(userID string, targetID string) bool) {
+	accessChecks[targetType] = check
+}
+
+func find(text string) []match {
+	var matches []match
+	for _, loc := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		username := strings.TrimRight(text[loc[2]:loc[3]], ".-")
+		if username == "" {
+			continue
+		}
+		// loc[2] points past the "@", the span starts on it
+		matches = append(matches, match{start: loc[2] - 1, end: loc[2] + len(username), username: username})
+	}
+	return matches
+}
+
+// Extract returns the distinct usernames mentioned in text, whether or not
+// they exist.
+func Extract(text string) []string {
+	seen := map[string]bool{}
+	var usernames []string
+	for _, m := range find(text) {
+		if seen[m.username] {
+			continue
+		}
+		seen[m.username] = true
+		usernames = append(usernames, m.username)
+	}
+	return usernames
+}
+
+func resolve(usernames []string) (map[string]string, error) {
+	users := map[string]string{}
+	if len(usernames) == 0 {
+		return users, nil
+	}
+	args := make([]interface{}, len(usernames))
+	for i, username := range usernames {
+		args[i] = username
+	}
+	rows, err := db.DB.Query("SELECT username, id FROM users WHERE username IN (?"+strings.Repeat(", ?", len(usernames)-1)+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username, userID string
+		if err := rows.Scan(&username, &userID); err != nil {
+			return nil, err
+		}
+		users[username] = userID
+	}
+	return users, rows.Err()
+}
+
+// Spans splits text into plain and mention spans. Handles that don't belong
+// to a user stay plain text.
+func Spans(text string) []Span {
+	matches := find(text)
+	if len(matches) == 0 {
+		return []Span{{Text: text}}
+	}
+	users, err := resolve(Extract(text))
+	if err != nil {
+		logger.LogError("Error resolving mentions", err)
+		return []Span{{Text: text}}
+	}
+
+	var spans []Span
+	last := 0
+	for _, m := range matches {
+		if _, ok := users[m.username]; !ok {
+			continue
+		}
+		if m.start > last {
+			spans = append(spans, Span{Text: text[last:m.start]})
+		}
+		spans = append(spans, Span{Text: text[m.start:m.end], Username: m.username})
+		last = m.end
+	}
+	if last < len(text) || len(spans) == 0 {
+		spans = append(spans, Span{Text: text[last:]})
+	}
+	return spans
+}
+
+// Record syncs the mention records of a target with its text and notifies
+// users that are newly mentioned. Only users who can see the content are
+// recorded.
+func Record(targetType, targetID, authorID, text string) {
+	users, err := resolve(Extract(text))
+	if err != nil {
+		logger.LogError("Error resolving mentions", err)
+		return
+	}
+
+	args := []interface{}{targetType, targetID}
+	query := "DELETE FROM mentions WHERE target_type = ? AND target_id = ?"
+	if len(users) > 0 {
+		query += " AND mentioned_user_id NOT IN (?" + strings.Repeat(", ?", len(users)-1) + ")"
+		for _, userID := range users {
+			args = append(args, userID)
+		}
+	}
+	if _, err := db.DB.Exec(query, args...); err != nil {
+		logger.LogError("Error removing mentions", err)
+		return
+	}
+
+	author, ok := session.GetUsernameFromUserID(authorID)
+	if !ok {
+		return
+	}
+	for username, userID := range users {
+		if userID == authorID {
+			continue
+		}
+		// users who can't see the content get no row yet, so they are still
+		// notified if it is shared with them later
+		check, ok := accessChecks[targetType]
+		if !ok || !check(userID, targetID) {
+			continue
+		}
+		mentionID, err := uuid.NewV7()
+		if err != nil {
+			logger.LogError("Error generating mention ID", err)
+			return
+		}
+		result, err := db.DB.Exec(`
+			INSERT OR IGNORE INTO mentions (id, target_type, target_id, author_id, mentioned_user_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, mentionID.String(), targetType, targetID, authorID, userID, time.Now())
+		if err != nil {
+			logger.LogError("Error saving mention", err)
+			continue
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		notification.CreateNotificationAbout(username, author, notification.TypeMention,
+			author+" mentioned you in "+targetLabels[targetType], targetType, targetID)
+	}
+}
+
+// RecordPost is run when a post is published or its audience changes, and
+// picks up mentions in the post and its comments that have become visible.
+func RecordPost(postID string) {
+	var authorID, content string
+	if err := db.DB.QueryRow("SELECT user_id, content FROM posts WHERE id = ?", postID).Scan(&authorID, &content); err != nil {
+		logger.LogError("Error fetching post for mentions", err)
+		return
+	}
+	Record(TargetPost, postID, authorID, content)
+
+	rows, err := db.DB.Query(`
+		SELECT c.id, u.id, c.content FROM comments c
+		JOIN users u ON u.username = c.author
+		WHERE c.target_type = 'post' AND c.target_id = ?
+	`, postID)
+	if err != nil {
+		logger.LogError("Error fetching comments for mentions", err)
+		return
+	}
+	type comment struct{ id, authorID, content string }
+	var comments []comment
+	for rows.Next() {
+		var c comment
+		if err := rows.Scan(&c.id, &c.authorID, &c.content); err != nil {
+			logger.LogError("Error scanning comment for mentions", err)
+			continue
+		}
+		comments = append(comments, c)
+	}
+	rows.Close()
+	for _, c := range comments {
+		Record(TargetComment, c.id, c.authorID, c.content)
+	}
+}
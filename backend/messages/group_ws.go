@@ -10,6 +10,9 @@ import (
 	"time"
 
 	"social-net/db"
+	"social-net/filters"
+	"social-net/mentions"
+	"social-net/moderation"
 	"social-net/notification"
 	"social-net/session"
 
@@ -126,6 +129,16 @@ func HandleGroupWebSocket(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		verdict, err := filters.Check(groupID, &msgReq.Content)
+		if err != nil {
+			log.Printf("Error applying word filters: %v", err)
+			continue
+		}
+		if verdict.Blocked {
+			conn.WriteJSON(map[string]string{"error": filters.BlockedMessage})
+			continue
+		}
+
 		messageID, err := uuid.NewV4()
 		if err != nil {
 			log.Printf("Error generating UUID: %v", err)
@@ -144,6 +157,10 @@ func HandleGroupWebSocket(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error saving message: %v", err)
 			continue
 		}
+		mentions.Record(mentions.TargetGroupMessage, msg.ID, userID, msg.Content)
+		if err := verdict.Flag(db.DB, moderation.TargetGroupMessage, msg.ID, groupID); err != nil {
+			log.Printf("Error flagging message: %v", err)
+		}
 		members, err := getGroupMembers(groupID)
 		if err != nil {
 			log.Printf("Error getting group members: %v", err)
@@ -163,6 +180,30 @@ func HandleGroupWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func CheckUserMessagePermission(userID string, messageID string) bool {
+	var exists bool
+	err := db.DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM messages
+			WHERE id = ? AND (sender_id = ? OR receiver_id = ?)
+		)`, messageID, userID, userID).Scan(&exists)
+	if err != nil {
+		log.Printf("Error checking message permission: %v", err)
+		return false
+	}
+	return exists
+}
+
+func CheckUserGroupMessagePermission(userID string, messageID string) bool {
+	var groupID string
+	err := db.DB.QueryRow("SELECT group_id FROM group_messages WHERE id = ?", messageID).Scan(&groupID)
+	if err != nil {
+		log.Printf("Error fetching group message: %v", err)
+		return false
+	}
+	return isGroupMember(userID, groupID)
+}
+
 func isGroupExist(groupID string) bool {
 	var exists bool
 	query := `
@@ -206,7 +247,7 @@ func sendRecentMessages(conn *websocket.Conn, groupID string) {
 		SELECT m.id, m.group_id, m.sender_id, m.content, m.created_at, u.username, u.avatar
 		FROM group_messages m
 		JOIN users u ON m.sender_id = u.id
-		WHERE m.group_id = ?
+		WHERE m.group_id = ? AND ` + moderation.Visible(moderation.TargetGroupMessage, "m.id") + `
 		ORDER BY m.created_at DESC
 		LIMIT 50
 	`
@@ -242,6 +283,8 @@ func sendRecentMessages(conn *websocket.Conn, groupID string) {
 			"created_at": msg.CreatedAt,
 			"username":   username,
 			"avatar":     avatarURL,
+
+			"content_spans": mentions.Spans(msg.Content),
 		}
 		messages = append(messages, messageMap)
 	}
@@ -275,6 +318,8 @@ func broadcastGroupMessage(msg GroupMessage) {
 		"content":    msg.Content,
 		"created_at": msg.CreatedAt,
 		"username":   username,
+
+		"content_spans": mentions.Spans(msg.Content),
 	}
 
 	groupMutex.Lock()
@@ -9,8 +9,13 @@ import (
 	"time"
 
 	"social-net/db"
+	"social-net/filters"
 	logger "social-net/log"
+	"social-net/mentions"
+	"social-net/moderation"
 	"social-net/notification"
+	"social-net/pagination"
+	"social-net/previews"
 	"social-net/session"
 
 	"github.com/gofrs/uuid"
@@ -23,6 +28,9 @@ type Message struct {
 	Receiver string    `json:"receiver"`
 	Time     time.Time `json:"time"`
 	Type     string    `json:"type"`
+
+	ContentSpans []mentions.Span    `json:"content_spans,omitempty"`
+	LinkPreviews []previews.Preview `json:"link_previews,omitempty"`
 }
 
 var (
@@ -81,9 +89,29 @@ func Handleconnections(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
+		var verdict filters.Verdict
+		if msg.Type != "typing" {
+			verdict, err = filters.Check("", &msg.Message)
+			if err != nil {
+				logger.LogError("Error applying word filters", err)
+				continue
+			}
+			if verdict.Blocked {
+				conn.WriteJSON(map[string]string{"error": filters.BlockedMessage})
+				continue
+			}
+			msg.ContentSpans = mentions.Spans(msg.Message)
+		}
 		sendMessageToRecipient(msg)
 		notification.CreateNotificationMessage(msg.Receiver, msg.Username, "message", msg.Message)
-		saveMessageToDB(msg.Username, msg.Receiver, msg.Message, msg.Type)
+		messageID, err := saveMessageToDB(msg.Username, msg.Receiver, msg.Message, msg.Type)
+		if err == nil && messageID != "" {
+			mentions.Record(mentions.TargetMessage, messageID, userid, msg.Message)
+			if err := verdict.Flag(db.DB, moderation.TargetMessage, messageID, ""); err != nil {
+				logger.LogError("Error flagging message", err)
+			}
+			go previews.Warm(msg.Message)
+		}
 	}
 	clientsMutex.Lock()
 	conns := clients[username]
@@ -102,42 +130,43 @@ func Handleconnections(w http.ResponseWriter, r *http.Request) {
 	broadcastOnlineUsers()
 }
 
-func saveMessageToDB(sender string, receiver string, message string, typee string) error {
+func saveMessageToDB(sender string, receiver string, message string, typee string) (string, error) {
 	senderID, err := session.GetUserIDFromUsername(sender)
 	if err != nil {
 		logger.LogError("Failed to get sender ID", err)
-		return fmt.Errorf("failed to get sender ID: %w", err)
+		return "", fmt.Errorf("failed to get sender ID: %w", err)
 	}
 
 	receiverID, err := session.GetUserIDFromUsername(receiver)
 	if err != nil {
 		logger.LogError("Failed to get receiver ID", err)
-		return fmt.Errorf("failed to get receiver ID: %w", err)
+		return "", fmt.Errorf("failed to get receiver ID: %w", err)
 	}
 
 	if senderID == receiverID {
-		return fmt.Errorf("sender and receiver cannot be the same")
+		return "", fmt.Errorf("sender and receiver cannot be the same")
 	}
 	messageID, errr := uuid.NewV7()
 	if errr != nil {
-		return fmt.Errorf("failed to generate message ID: %w", errr)
+		return "", fmt.Errorf("failed to generate message ID: %w", errr)
 	}
 	if typee != "typing" {
 		pre, err := db.DB.Prepare("INSERT INTO messages (id,sender_id, receiver_id, content, creation_date) VALUES (?,?, ?, ?, ?)")
 		if err != nil {
 			logger.LogError("Failed to prepare statement", err)
-			return fmt.Errorf("failed to prepare statement: %w", err)
+			return "", fmt.Errorf("failed to prepare statement: %w", err)
 		}
 		defer pre.Close()
 
 		_, err = pre.Exec(messageID, senderID, receiverID, message, time.Now())
 		if err != nil {
 			logger.LogError("Failed to execute statement", err)
-			return fmt.Errorf("failed to execute statement: %w", err)
+			return "", fmt.Errorf("failed to execute statement: %w", err)
 		}
+		return messageID.String(), nil
 	}
 
-	return nil
+	return "", nil
 }
 
 func GetMessages(w http.ResponseWriter, r *http.Request) {
@@ -200,12 +229,23 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, afterArgs := page.Where("creation_date", "id")
+
+	// pages walk back from the newest message and are flipped to oldest first
+	args := append([]interface{}{senderID, receiverID, receiverID, senderID, userid}, afterArgs...)
 	rows, err := db.DB.Query(`
-		SELECT sender_id, receiver_id, content, creation_date
+		SELECT id, sender_id, receiver_id, content, creation_date, CAST(creation_date AS TEXT)
 		FROM messages
-		WHERE (sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)
-		ORDER BY creation_date ASC
-	`, senderID, receiverID, receiverID, senderID)
+		WHERE ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))
+			AND (sender_id = ? OR `+moderation.Visible(moderation.TargetMessage, "id")+`) AND `+after+`
+		ORDER BY creation_date DESC, id DESC
+		LIMIT ?
+	`, append(args, page.Fetch())...)
 	if err != nil {
 		http.Error(w, "Failed to fetch messages", http.StatusInternalServerError)
 		logger.LogError("Failed to fetch messages", err)
@@ -213,13 +253,19 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	var messages []Message
+	messages := []Message{}
+	var scanned int
+	var last pagination.Cursor
 	for rows.Next() {
+		scanned++
+		if scanned > page.Limit {
+			break
+		}
 		var senderID, receiverID string
 		var content string
 		var creationDate time.Time
 
-		err := rows.Scan(&senderID, &receiverID, &content, &creationDate)
+		err := rows.Scan(&last.ID, &senderID, &receiverID, &content, &creationDate, &last.Time)
 		if err != nil {
 			http.Error(w, "Error scanning message row", http.StatusInternalServerError)
 			logger.LogError("Error scanning message row", err)
@@ -234,6 +280,9 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 			Message:  content,
 			Receiver: receiverUsername,
 			Time:     creationDate,
+
+			ContentSpans: mentions.Spans(content),
+			LinkPreviews: previews.ForText(content),
 		})
 	}
 
@@ -243,10 +292,17 @@ func GetMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	err = json.NewEncoder(w).Encode(messages)
+	err = json.NewEncoder(w).Encode(pagination.Response{
+		Items:      messages,
+		NextCursor: page.Next(scanned, last),
+	})
 	if err != nil {
 		http.Error(w, "Failed to encode messages to JSON", http.StatusInternalServerError)
 		logger.LogError("Failed to encode messages to JSON", err)
@@ -0,0 +1,596 @@
+package moderation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"social-net/attachments"
+	"social-net/db"
+	"social-net/imaging"
+	logger "social-net/log"
+	"social-net/pagination"
+	"social-net/session"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	TargetPost         = "post"
+	TargetComment      = "comment"
+	TargetGroupPost    = "group_post"
+	TargetMessage      = "message"
+	TargetGroupMessage = "group_message"
+	TargetProfile      = "profile"
+
+	StatusOpen      = "open"
+	StatusActioned  = "actioned"
+	StatusDismissed = "dismissed"
+
+	DefaultAutoHideThreshold = 5
+	MaxDetailsLength         = 500
+)
+
+var Reasons = map[string]bool{
+	"spam":           true,
+	"harassment":     true,
+	"hate":           true,
+	"violence":       true,
+	"nudity":         true,
+	"misinformation": true,
+	"other":          true,
+}
+
+// transitions lists the statuses a report may move to from each status.
+// Closed reports can be reopened if a decision needs revisiting.
+var transitions = map[string]map[string]bool{
+	StatusOpen:      {StatusActioned: true, StatusDismissed: true},
+	StatusActioned:  {StatusOpen: true},
+	StatusDismissed: {StatusOpen: true},
+}
+
+type Report struct {
+	ID             string     `json:"id"`
+	Reporter       string     `json:"reporter"`
+	Source         string     `json:"source"`
+	TargetType     string     `json:"target_type"`
+	TargetID       string     `json:"target_id"`
+	GroupID        string     `json:"group_id"`
+	Reason         string     `json:"reason"`
+	Details        string     `json:"details"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ResolvedBy     string     `json:"resolved_by"`
+	ResolvedAt     *time.Time `json:"resolved_at"`
+	ResolutionNote string     `json:"resolution_note"`
+	Reporters      int        `json:"open_reporters"`
+	Hidden         bool       `json:"hidden"`
+	// Content is nil once the reported content has been deleted.
+	Content *Snapshot `json:"content"`
+}
+
+// Snapshot is what reported content says, so moderators can judge it even
+// where they couldn't otherwise see it.
+type Snapshot struct {
+	Author    string   `json:"author"`
+	Title     string   `json:"title"`
+	Content   string   `json:"content"`
+	Images    []string `json:"images"`
+	CreatedAt string   `json:"created_at"`
+}
+
+type ReportRequest struct {
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+	Reason     string `json:"reason"`
+	Details    string `json:"details"`
+}
+
+type ResolveRequest struct {
+	ReportID string `json:"report_id"`
+	Status   string `json:"status"`
+	Hide     bool   `json:"hide"`
+	Note     string `json:"note"`
+}
+
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+var (
+	accessChecks      = map[string]func(userID string, targetID string) bool{}
+	autoHideThreshold = DefaultAutoHideThreshold
+)
+
+func SetAccessCheck(targetType string, check func(userID string, targetID string) bool) {
+	accessChecks[targetType] = check
+}
+
+// SetAutoHideThreshold sets how many distinct users must have an open report
+// on something before it is hidden pending review.
+func SetAutoHideThreshold(n int) {
+	if n > 0 {
+		autoHideThreshold = n
+	}
+}
+
+// Visible is an SQL condition that is true when the row whose id is in idCol
+// hasn't been hidden. targetType must be one of the Target constants.
+func Visible(targetType, idCol string) string {
+	return "NOT EXISTS (SELECT 1 FROM hidden_content hc WHERE hc.target_type = '" + targetType + "' AND hc.target_id = " + idCol + ")"
+}
+
+func IsHidden(targetType, targetID string) bool {
+	var hidden bool
+	err := db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM hidden_content WHERE target_type = ? AND target_id = ?)", targetType, targetID).Scan(&hidden)
+	if err != nil {
+		logger.LogError("Error checking hidden content", err)
+		return false
+	}
+	return hidden
+}
+
+func IsModerator(userID string) bool {
+	var role string
+	if err := db.DB.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role); err != nil {
+		return false
+	}
+	return role == "moderator"
+}
+
+// IsGroupAdmin reports whether userID created groupID or is one of its admins.
+func IsGroupAdmin(userID, groupID string) bool {
+	var admin bool
+	err := db.DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM groups WHERE id = ? AND creator_id = ?)
+		OR EXISTS(SELECT 1 FROM group_members WHERE group_id = ? AND user_id = ? AND status = 'accepted' AND is_admin = 1)
+	`, groupID, userID, groupID, userID).Scan(&admin)
+	if err != nil {
+		logger.LogError("Error checking group admin", err)
+		return false
+	}
+	return admin
+}
+
+// groupOf returns the group that owns a piece of group content, or "" for
+// content that isn't in a group.
+func groupOf(targetType, targetID string) (string, error) {
+	var query string
+	switch targetType {
+	case TargetGroupPost:
+		query = "SELECT group_id FROM group_posts WHERE id = ?"
+	case TargetComment:
+		// comments on group posts and events belong to the group
+		query = `
+			SELECT COALESCE(gp.group_id, e.group_id, '') FROM comments c
+			LEFT JOIN group_posts gp ON c.target_type = 'group_post' AND gp.id = c.target_id
+			LEFT JOIN events e ON c.target_type = 'event' AND e.id = c.target_id
+			WHERE c.id = ?`
+	case TargetGroupMessage:
+		query = "SELECT group_id FROM group_messages WHERE id = ?"
+	default:
+		return "", nil
+	}
+	var groupID string
+	err := db.DB.QueryRow(query, targetID).Scan(&groupID)
+	return groupID, err
+}
+
+// snapshot loads the reported content itself.
+func snapshot(targetType, targetID string) (*Snapshot, error) {
+	var query string
+	switch targetType {
+	case TargetPost:
+		query = "SELECT author, title, content, image, CAST(creation_date AS TEXT) FROM posts WHERE id = ?"
+	case TargetComment:
+		query = "SELECT author, '', content, image, CAST(creation_date AS TEXT) FROM comments WHERE id = ?"
+	case TargetGroupPost:
+		query = `
+			SELECT COALESCE(u.username, ''), gp.title, gp.content, gp.image, CAST(gp.creation_date AS TEXT)
+			FROM group_posts gp LEFT JOIN users u ON u.id = gp.user_id WHERE gp.id = ?`
+	case TargetMessage:
+		query = `
+			SELECT COALESCE(u.username, ''), '', m.content, '', CAST(m.creation_date AS TEXT)
+			FROM messages m LEFT JOIN users u ON u.id = m.sender_id WHERE m.id = ?`
+	case TargetGroupMessage:
+		query = `
+			SELECT COALESCE(u.username, ''), '', m.content, '', CAST(m.created_at AS TEXT)
+			FROM group_messages m LEFT JOIN users u ON u.id = m.sender_id WHERE m.id = ?`
+	case TargetProfile:
+		query = "SELECT username, COALESCE(nickname, ''), COALESCE(bio, ''), avatar, '' FROM users WHERE id = ?"
+	default:
+		return nil, nil
+	}
+
+	var snap Snapshot
+	var image sql.NullString
+	err := db.DB.QueryRow(query, targetID).Scan(&snap.Author, &snap.Title, &snap.Content, &image, &snap.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snap.Images = []string{}
+	if targetType == TargetPost || targetType == TargetGroupPost {
+		list, err := attachments.List(targetType, targetID)
+		if err != nil {
+			return nil, err
+		}
+		for _, attachment := range list {
+			snap.Images = append(snap.Images, attachment.URL)
+		}
+	}
+	if len(snap.Images) == 0 && image.String != "" {
+		snap.Images = append(snap.Images, imaging.BaseURL+image.String)
+	}
+	return &snap, nil
+}
+
+func authenticate(w http.ResponseWriter, r *http.Request, method string) (string, bool) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", method+", OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return "", false
+	}
+	if r.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return "", false
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+// CreateReport files a report. Each user can report a given item once; when
+// enough different users have open reports on it, it is hidden until a
+// moderator looks at it.
+func CreateReport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request ReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !Reasons[request.Reason] {
+		http.Error(w, "Invalid reason", http.StatusBadRequest)
+		return
+	}
+	request.Details = strings.TrimSpace(request.Details)
+	if len([]rune(request.Details)) > MaxDetailsLength {
+		http.Error(w, "Details must not exceed 500 characters", http.StatusBadRequest)
+		return
+	}
+	if request.TargetID == "" {
+		http.Error(w, "Missing target_id", http.StatusBadRequest)
+		return
+	}
+
+	if request.TargetType == TargetProfile {
+		var profileID string
+		err := db.DB.QueryRow("SELECT id FROM users WHERE id = ? OR username = ?", request.TargetID, request.TargetID).Scan(&profileID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if profileID == userID {
+			http.Error(w, "You cannot report yourself", http.StatusBadRequest)
+			return
+		}
+		request.TargetID = profileID
+	} else {
+		check, ok := accessChecks[request.TargetType]
+		if !ok {
+			http.Error(w, "Invalid target_type", http.StatusBadRequest)
+			return
+		}
+		if !check(userID, request.TargetID) {
+			http.Error(w, "Content not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	groupID, err := groupOf(request.TargetType, request.TargetID)
+	if err != nil {
+		logger.LogError("Error resolving report group", err)
+		http.Error(w, "Error saving report", http.StatusInternalServerError)
+		return
+	}
+	var group interface{}
+	if groupID != "" {
+		group = groupID
+	}
+
+	reportID, err := uuid.NewV7()
+	if err != nil {
+		http.Error(w, "Failed to generate report ID", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT OR IGNORE INTO reports (id, reporter_id, target_type, target_id, group_id, reason, details, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, reportID.String(), userID, request.TargetType, request.TargetID, group, request.Reason, request.Details, time.Now())
+	if err != nil {
+		logger.LogError("Error saving report", err)
+		http.Error(w, "Error saving report", http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		http.Error(w, "You have already reported this", http.StatusConflict)
+		return
+	}
+
+	var reporters int
+	err = tx.QueryRow("SELECT COUNT(DISTINCT reporter_id) FROM reports WHERE target_type = ? AND target_id = ? AND status = 'open'",
+		request.TargetType, request.TargetID).Scan(&reporters)
+	if err != nil {
+		logger.LogError("Error counting reports", err)
+		http.Error(w, "Error saving report", http.StatusInternalServerError)
+		return
+	}
+	if reporters >= autoHideThreshold {
+		_, err = tx.Exec("INSERT OR IGNORE INTO hidden_content (target_type, target_id, reason, hidden_at) VALUES (?, ?, 'reports', ?)",
+			request.TargetType, request.TargetID, time.Now())
+		if err != nil {
+			logger.LogError("Error hiding reported content", err)
+			http.Error(w, "Error saving report", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Report submitted", "report_id": reportID.String()})
+}
+
+// Flag queues content that matched a word filter. It has no reporter, so it
+// doesn't count towards auto-hiding.
+func Flag(exec Execer, targetType, targetID, groupID string, matched []string) error {
+	reportID, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+	var group interface{}
+	if groupID != "" {
+		group = groupID
+	}
+	_, err = exec.Exec(`
+		INSERT INTO reports (id, source, target_type, target_id, group_id, reason, details, created_at)
+		VALUES (?, 'filter', ?, ?, ?, 'other', ?, ?)
+	`, reportID.String(), targetType, targetID, group, "Matched filter: "+strings.Join(matched, ", "), time.Now())
+	return err
+}
+
+// GetQueue lists reports newest first. Moderators see everything; group
+// admins see reports on content in the groups they run.
+func GetQueue(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = StatusOpen
+	}
+	if _, ok := transitions[status]; !ok {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, afterArgs := page.Where("r.created_at", "r.id")
+
+	scope := "1=1"
+	args := []interface{}{status}
+	groupID := r.URL.Query().Get("group_id")
+	switch {
+	case groupID != "":
+		if !IsModerator(userID) && !IsGroupAdmin(userID, groupID) {
+			http.Error(w, "Forbidden: You don't moderate this group", http.StatusForbidden)
+			return
+		}
+		scope = "r.group_id = ?"
+		args = append(args, groupID)
+	case !IsModerator(userID):
+		scope = `r.group_id IN (
+			SELECT id FROM groups WHERE creator_id = ?
+			UNION
+			SELECT group_id FROM group_members WHERE user_id = ? AND status = 'accepted' AND is_admin = 1)`
+		args = append(args, userID, userID)
+	}
+	args = append(args, afterArgs...)
+
+	rows, err := db.DB.Query(`
+		SELECT r.id, COALESCE(u.username, ''), r.source, r.target_type, r.target_id, COALESCE(r.group_id, ''), r.reason, r.details, r.status,
+			r.created_at, COALESCE(m.username, ''), r.resolved_at, r.resolution_note, CAST(r.created_at AS TEXT)
+		FROM reports r
+		LEFT JOIN users u ON u.id = r.reporter_id
+		LEFT JOIN users m ON m.id = r.resolved_by
+		WHERE r.status = ? AND `+scope+` AND `+after+`
+		ORDER BY r.created_at DESC, r.id DESC
+		LIMIT ?
+	`, append(args, page.Fetch())...)
+	if err != nil {
+		logger.LogError("Error fetching reports", err)
+		http.Error(w, "Error fetching reports", http.StatusInternalServerError)
+		return
+	}
+
+	reports := []Report{}
+	var scanned int
+	var last pagination.Cursor
+	for rows.Next() {
+		scanned++
+		if scanned > page.Limit {
+			break
+		}
+		var report Report
+		var resolvedAt sql.NullTime
+		err := rows.Scan(&report.ID, &report.Reporter, &report.Source, &report.TargetType, &report.TargetID, &report.GroupID, &report.Reason,
+			&report.Details, &report.Status, &report.CreatedAt, &report.ResolvedBy, &resolvedAt, &report.ResolutionNote, &last.Time)
+		if err != nil {
+			rows.Close()
+			logger.LogError("Error scanning report", err)
+			http.Error(w, "Error fetching reports", http.StatusInternalServerError)
+			return
+		}
+		last.ID = report.ID
+		if resolvedAt.Valid {
+			report.ResolvedAt = &resolvedAt.Time
+		}
+		reports = append(reports, report)
+	}
+	rows.Close()
+
+	for i := range reports {
+		err := db.DB.QueryRow("SELECT COUNT(DISTINCT reporter_id) FROM reports WHERE target_type = ? AND target_id = ? AND status = 'open'",
+			reports[i].TargetType, reports[i].TargetID).Scan(&reports[i].Reporters)
+		if err != nil {
+			logger.LogError("Error counting reports", err)
+		}
+		reports[i].Hidden = IsHidden(reports[i].TargetType, reports[i].TargetID)
+		reports[i].Content, err = snapshot(reports[i].TargetType, reports[i].TargetID)
+		if err != nil {
+			logger.LogError("Error loading reported content", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.Response{
+		Items:      reports,
+		NextCursor: page.Next(scanned, last),
+	})
+}
+
+// ResolveReport moves a report to a new status. Closing a report closes the
+// other open reports on the same content too: actioning can hide the
+// content, dismissing restores it. Only moderators can restore content a
+// moderator hid.
+func ResolveReport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticate(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	var request ResolveRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	request.Note = strings.TrimSpace(request.Note)
+	if len([]rune(request.Note)) > MaxDetailsLength {
+		http.Error(w, "Note must not exceed 500 characters", http.StatusBadRequest)
+		return
+	}
+
+	var status, targetType, targetID string
+	var groupID sql.NullString
+	err := db.DB.QueryRow("SELECT status, target_type, target_id, group_id FROM reports WHERE id = ?", request.ReportID).
+		Scan(&status, &targetType, &targetID, &groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Report not found", http.StatusNotFound)
+			return
+		}
+		logger.LogError("Error fetching report", err)
+		http.Error(w, "Error fetching report", http.StatusInternalServerError)
+		return
+	}
+	if !IsModerator(userID) && !(groupID.Valid && IsGroupAdmin(userID, groupID.String)) {
+		http.Error(w, "Forbidden: You cannot moderate this report", http.StatusForbidden)
+		return
+	}
+	if !transitions[status][request.Status] {
+		http.Error(w, "Cannot move a report from "+status+" to "+request.Status, http.StatusBadRequest)
+		return
+	}
+	if request.Hide && request.Status != StatusActioned {
+		http.Error(w, "Only actioned reports can hide content", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if request.Status == StatusOpen {
+		_, err = tx.Exec("UPDATE reports SET status = 'open', resolved_by = NULL, resolved_at = NULL, resolution_note = '' WHERE id = ?", request.ReportID)
+	} else {
+		_, err = tx.Exec(`
+			UPDATE reports SET status = ?, resolved_by = ?, resolved_at = ?, resolution_note = ?
+			WHERE id = ? OR (target_type = ? AND target_id = ? AND status = 'open')
+		`, request.Status, userID, now, request.Note, request.ReportID, targetType, targetID)
+	}
+	if err == nil {
+		switch {
+		case request.Hide:
+			_, err = tx.Exec(`
+				INSERT INTO hidden_content (target_type, target_id, reason, hidden_at) VALUES (?, ?, 'moderator', ?)
+				ON CONFLICT (target_type, target_id) DO UPDATE SET reason = 'moderator'
+			`, targetType, targetID, now)
+		case request.Status == StatusDismissed && IsModerator(userID):
+			_, err = tx.Exec("DELETE FROM hidden_content WHERE target_type = ? AND target_id = ?", targetType, targetID)
+		case request.Status == StatusDismissed:
+			// group admins can lift an automatic hide, not a moderator's
+			_, err = tx.Exec("DELETE FROM hidden_content WHERE target_type = ? AND target_id = ? AND reason = 'reports'", targetType, targetID)
+		}
+	}
+	if err != nil {
+		logger.LogError("Error resolving report", err)
+		http.Error(w, "Error resolving report", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Report updated", "status": request.Status})
+}
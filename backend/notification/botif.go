@@ -1,7 +1,6 @@
 package notification
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,6 +9,7 @@ import (
 	"time"
 
 	"social-net/db"
+	"social-net/pagination"
 	"social-net/session"
 
 	"github.com/gofrs/uuid"
@@ -18,6 +18,24 @@ import (
 
 var dbMutex sync.Mutex
 
+// UnavailableContent replaces the text of a notification about content the
+// user can no longer see, such as a post whose visibility was narrowed.
+const UnavailableContent = "This content is no longer available"
+
+var accessChecks = map[string]func(userID string, targetID string) bool{}
+
+func SetAccessCheck(entityType string, check func(userID string, targetID string) bool) {
+	accessChecks[entityType] = check
+}
+
+func canSee(userID, entityType, entityID string) bool {
+	if entityID == "" {
+		return true
+	}
+	check, ok := accessChecks[entityType]
+	return !ok || check(userID, entityID)
+}
+
 var (
 	notificationClients  = make(map[string][]*websocket.Conn)
 	notificationMutex    sync.Mutex
@@ -35,6 +53,20 @@ const (
 	TypeGroupRequest  = "group_request"
 	TypeEventCreated  = "event_created"
 	TypeGroupMessage  = "group_message"
+	TypeReaction      = "reaction"
+	TypeMention       = "mention"
+	TypeRepost        = "repost"
+	TypeReply         = "reply"
+)
+
+// Kinds of content a notification can refer to; they match the target types
+// used by mentions and reactions.
+const (
+	EntityPost         = "post"
+	EntityComment      = "comment"
+	EntityGroupPost    = "group_post"
+	EntityMessage      = "message"
+	EntityGroupMessage = "group_message"
 )
 
 type NotificationWebSocketMessage struct {
@@ -125,6 +157,13 @@ func BroadcastNotificationToUser(username string, notification Notification) {
 }
 
 func CreateNotificationMessage(userUS string, senderUS string, notifType string, content string) error {
+	return CreateNotificationAbout(userUS, senderUS, notifType, content, "", "")
+}
+
+// CreateNotificationAbout is CreateNotificationMessage for notifications that
+// refer to a piece of content, so they can be hidden from a user who loses
+// access to it later.
+func CreateNotificationAbout(userUS, senderUS, notifType, content, entityType, entityID string) error {
 	userID, _ := session.GetUserIDFromUsername(userUS)
 	senderID, _ := session.GetUserIDFromUsername(senderUS)
 	fmt.Println("XXXXXXX2")
@@ -141,12 +180,12 @@ func CreateNotificationMessage(userUS string, senderUS string, notifType string,
 	defer dbMutex.Unlock()
 
 	query := `
-	INSERT INTO notifications (id, user_id, sender_id, type, content, is_read, created_at)
-	VALUES (?, ?, ?, ?, ?, 0, ?)
+	INSERT INTO notifications (id, user_id, sender_id, type, content, is_read, created_at, related_entity_type, related_entity_id)
+	VALUES (?, ?, ?, ?, ?, 0, ?, NULLIF(?, ''), NULLIF(?, ''))
 	`
 
 	createdAt := time.Now()
-	_, err = db.DB.Exec(query, notificationID.String(), userID, senderID, notifType, formattedContent, createdAt)
+	_, err = db.DB.Exec(query, notificationID.String(), userID, senderID, notifType, formattedContent, createdAt, entityType, entityID)
 	if err != nil {
 		fmt.Println("Error generating notification ID:", err)
 		return fmt.Errorf("failed to insert notification: %w", err)
@@ -162,6 +201,9 @@ func CreateNotificationMessage(userUS string, senderUS string, notifType string,
 		IsRead:         false,
 		CreatedAt:      createdAt,
 		SenderUsername: senderUS,
+
+		RelatedEntityID:   entityID,
+		RelatedEntityType: entityType,
 	}
 
 	go BroadcastNotificationToUser(userUS, notification)
@@ -170,16 +212,16 @@ func CreateNotificationMessage(userUS string, senderUS string, notifType string,
 }
 
 type Notification struct {
-	ID                string         `json:"id"`
-	UserID            string         `json:"user_id"`
-	SenderID          string         `json:"sender_id"`
-	Type              string         `json:"type"`
-	Content           string         `json:"content"`
-	IsRead            bool           `json:"is_read"`
-	CreatedAt         time.Time      `json:"created_at"`
-	SenderUsername    string         `json:"sender_username"`
-	RelatedEntityID   sql.NullString `json:"related_entity_id"`
-	RelatedEntityType sql.NullString `json:"related_entity_type"`
+	ID                string    `json:"id"`
+	UserID            string    `json:"user_id"`
+	SenderID          string    `json:"sender_id"`
+	Type              string    `json:"type"`
+	Content           string    `json:"content"`
+	IsRead            bool      `json:"is_read"`
+	CreatedAt         time.Time `json:"created_at"`
+	SenderUsername    string    `json:"sender_username"`
+	RelatedEntityID   string    `json:"related_entity_id,omitempty"`
+	RelatedEntityType string    `json:"related_entity_type,omitempty"`
 }
 
 func GetNotifications(w http.ResponseWriter, r *http.Request) {
@@ -201,6 +243,13 @@ func GetNotifications(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, afterArgs := page.Where("n.created_at", "n.id")
+
 	query := `
 		SELECT 
 			n.id,
@@ -210,14 +259,19 @@ func GetNotifications(w http.ResponseWriter, r *http.Request) {
 			n.content,
 			n.is_read,
 			n.created_at,
-			u.username as sender_username
+			u.username as sender_username,
+			COALESCE(n.related_entity_type, ''),
+			COALESCE(n.related_entity_id, ''),
+			CAST(n.created_at AS TEXT)
 		FROM notifications n
 		LEFT JOIN users u ON n.sender_id = u.id
-		WHERE n.user_id = $1
-		ORDER BY n.created_at DESC
+		WHERE n.user_id = ? AND ` + after + `
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT ?
 	`
 
-	rows, err := db.DB.Query(query, userID)
+	args := append([]interface{}{userID}, afterArgs...)
+	rows, err := db.DB.Query(query, append(args, page.Fetch())...)
 	if err != nil {
 		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
 		return
@@ -233,10 +287,20 @@ func GetNotifications(w http.ResponseWriter, r *http.Request) {
 		Content        string    `json:"content"`
 		IsRead         bool      `json:"is_read"`
 		CreatedAt      time.Time `json:"created_at"`
+
+		RelatedEntityType string `json:"related_entity_type,omitempty"`
+		RelatedEntityID   string `json:"related_entity_id,omitempty"`
+		Unavailable       bool   `json:"unavailable,omitempty"`
 	}
 
 	notifications := []NotificationResponse{}
+	var scanned int
+	var last pagination.Cursor
 	for rows.Next() {
+		scanned++
+		if scanned > page.Limit {
+			break
+		}
 		var n NotificationResponse
 		err := rows.Scan(
 			&n.ID,
@@ -247,15 +311,27 @@ func GetNotifications(w http.ResponseWriter, r *http.Request) {
 			&n.IsRead,
 			&n.CreatedAt,
 			&n.SenderUsername,
+			&n.RelatedEntityType,
+			&n.RelatedEntityID,
+			&last.Time,
 		)
+		last.ID = n.ID
 		if err != nil {
 			continue
 		}
+		if !canSee(userID, n.RelatedEntityType, n.RelatedEntityID) {
+			n.Content = UnavailableContent
+			n.RelatedEntityType, n.RelatedEntityID = "", ""
+			n.Unavailable = true
+		}
 		notifications = append(notifications, n)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(notifications)
+	json.NewEncoder(w).Encode(pagination.Response{
+		Items:      notifications,
+		NextCursor: page.Next(scanned, last),
+	})
 }
 
 func MarkNotificationAsRead(w http.ResponseWriter, r *http.Request) {
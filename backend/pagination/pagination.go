@@ -0,0 +1,110 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Cursor points at the last row of a page. Time holds the creation time
+// exactly as stored (selected with CAST(... AS TEXT)) so comparisons in SQL
+// match the ORDER BY; it is empty for lists ordered by id only.
+type Cursor struct {
+	Time string `json:"t,omitempty"`
+	ID   string `json:"id"`
+	// Score and At are set by lists ordered by a computed score: the last
+	// row's score and the time scores were computed at, so later pages rank
+	// with the same clock.
+	Score float64 `json:"s,omitempty"`
+	At    string  `json:"at,omitempty"`
+}
+
+type Page struct {
+	Limit int
+	After *Cursor
+}
+
+type Response struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+func Encode(cursor Cursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func Decode(value string) (Cursor, error) {
+	var cursor Cursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, errors.New("invalid cursor")
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return cursor, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
+
+// FromRequest reads the limit and cursor query parameters.
+func FromRequest(r *http.Request) (Page, error) {
+	page := Page{Limit: DefaultLimit}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return page, errors.New("invalid limit")
+		}
+		if limit > MaxLimit {
+			limit = MaxLimit
+		}
+		page.Limit = limit
+	}
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		cursor, err := Decode(value)
+		if err != nil {
+			return page, err
+		}
+		page.After = &cursor
+	}
+	return page, nil
+}
+
+// Where returns the keyset condition for a list ordered by timeCol DESC,
+// idCol DESC, or "1=1" on the first page.
+func (p Page) Where(timeCol, idCol string) (string, []interface{}) {
+	if p.After == nil {
+		return "1=1", nil
+	}
+	return "(" + timeCol + " < ? OR (" + timeCol + " = ? AND " + idCol + " < ?))",
+		[]interface{}{p.After.Time, p.After.Time, p.After.ID}
+}
+
+// WhereID is Where for lists ordered by idCol DESC alone.
+func (p Page) WhereID(idCol string) (string, []interface{}) {
+	if p.After == nil {
+		return "1=1", nil
+	}
+	return idCol + " < ?", []interface{}{p.After.ID}
+}
+
+// Fetch is the LIMIT to query with: one extra row tells whether another page
+// exists.
+func (p Page) Fetch() int {
+	return p.Limit + 1
+}
+
+// Next returns the cursor for the following page given how many rows were
+// scanned and the cursor of the last row that belongs to this page.
+func (p Page) Next(scanned int, last Cursor) string {
+	if scanned <= p.Limit {
+		return ""
+	}
+	return Encode(last)
+}
@@ -0,0 +1,486 @@
+package polls
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/session"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	TargetPost      = "post"
+	TargetGroupPost = "group_post"
+
+	MinOptions      = 2
+	MaxOptions      = 10
+	MaxOptionLength = 100
+
+	ResultsAlways     = "always"
+	ResultsAfterVote  = "after_vote"
+	ResultsAfterClose = "after_close"
+)
+
+type Request struct {
+	Options           []string
+	Multiple          bool
+	Anonymous         bool
+	ResultsVisibility string
+	ClosesAt          *time.Time
+}
+
+type Option struct {
+	ID       string   `json:"id"`
+	Text     string   `json:"text"`
+	Position int      `json:"position"`
+	Votes    *int     `json:"votes"`
+	Voters   []string `json:"voters,omitempty"`
+}
+
+type Poll struct {
+	ID                string     `json:"id"`
+	Multiple          bool       `json:"multiple"`
+	Anonymous         bool       `json:"anonymous"`
+	ResultsVisibility string     `json:"results_visibility"`
+	ClosesAt          *time.Time `json:"closes_at"`
+	Closed            bool       `json:"closed"`
+	ResultsVisible    bool       `json:"results_visible"`
+	TotalVoters       *int       `json:"total_voters"`
+	MyVotes           []string   `json:"my_votes"`
+	Options           []Option   `json:"options"`
+}
+
+type VoteRequest struct {
+	PollID    string   `json:"poll_id"`
+	OptionIDs []string `json:"option_ids"`
+}
+
+var accessChecks = map[string]func(userID string, targetID string) bool{}
+
+// SetAccessCheck registers who may see (and vote on) polls attached to a
+// target type.
+func SetAccessCheck(targetType string, check func(userID string, targetID string) bool) {
+	accessChecks[targetType] = check
+}
+
+// FromForm reads the poll_* fields of a multipart post form. It returns nil
+// when the form has no poll.
+func FromForm(r *http.Request) (*Request, error) {
+	var options []string
+	for _, option := range r.MultipartForm.Value["poll_option"] {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	if len(options) == 0 {
+		return nil, nil
+	}
+	if len(options) < MinOptions || len(options) > MaxOptions {
+		return nil, fmt.Errorf("A poll needs between %d and %d options", MinOptions, MaxOptions)
+	}
+	seen := map[string]bool{}
+	for _, option := range options {
+		if len([]rune(option)) > MaxOptionLength {
+			return nil, fmt.Errorf("Poll options must not exceed %d characters", MaxOptionLength)
+		}
+		if seen[strings.ToLower(option)] {
+			return nil, errors.New("Poll options must be different")
+		}
+		seen[strings.ToLower(option)] = true
+	}
+
+	request := &Request{
+		Options:           options,
+		Multiple:          r.FormValue("poll_multiple") == "true",
+		Anonymous:         r.FormValue("poll_anonymous") != "false",
+		ResultsVisibility: r.FormValue("poll_results"),
+	}
+	switch request.ResultsVisibility {
+	case "":
+		request.ResultsVisibility = ResultsAlways
+	case ResultsAlways, ResultsAfterVote, ResultsAfterClose:
+	default:
+		return nil, errors.New("Invalid poll_results")
+	}
+	if value := strings.TrimSpace(r.FormValue("poll_closes_at")); value != "" {
+		closesAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, errors.New("poll_closes_at must be an RFC 3339 time")
+		}
+		if !closesAt.After(time.Now()) {
+			return nil, errors.New("poll_closes_at must be in the future")
+		}
+		closesAt = closesAt.UTC()
+		request.ClosesAt = &closesAt
+	}
+	if request.ResultsVisibility == ResultsAfterClose && request.ClosesAt == nil {
+		return nil, errors.New("Polls that show results after closing need poll_closes_at")
+	}
+	return request, nil
+}
+
+func Create(tx *sql.Tx, targetType, targetID, creatorID string, request *Request) error {
+	if request == nil {
+		return nil
+	}
+	pollID, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+	var closesAt interface{}
+	if request.ClosesAt != nil {
+		closesAt = *request.ClosesAt
+	}
+	_, err = tx.Exec(`
+		INSERT INTO polls (id, target_type, target_id, creator_id, multiple, anonymous, results_visibility, closes_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, pollID.String(), targetType, targetID, creatorID, request.Multiple, request.Anonymous, request.ResultsVisibility, closesAt, time.Now())
+	if err != nil {
+		return err
+	}
+	for i, text := range request.Options {
+		optionID, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO poll_options (id, poll_id, position, text) VALUES (?, ?, ?, ?)",
+			optionID.String(), pollID.String(), i, text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Delete(tx *sql.Tx, targetType, targetID string) error {
+	for _, query := range []string{
+		"DELETE FROM poll_votes WHERE poll_id IN (SELECT id FROM polls WHERE target_type = ? AND target_id = ?)",
+		"DELETE FROM poll_options WHERE poll_id IN (SELECT id FROM polls WHERE target_type = ? AND target_id = ?)",
+		"DELETE FROM polls WHERE target_type = ? AND target_id = ?",
+	} {
+		if _, err := tx.Exec(query, targetType, targetID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForTarget returns the poll attached to a post as userID sees it, or nil.
+func ForTarget(userID, targetType, targetID string) (*Poll, error) {
+	var pollID string
+	err := db.DB.QueryRow("SELECT id FROM polls WHERE target_type = ? AND target_id = ?", targetType, targetID).Scan(&pollID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return load(userID, pollID)
+}
+
+func load(userID, pollID string) (*Poll, error) {
+	var poll Poll
+	var creatorID string
+	var closesAt sql.NullTime
+	err := db.DB.QueryRow(`
+		SELECT id, creator_id, multiple, anonymous, results_visibility, closes_at
+		FROM polls WHERE id = ?
+	`, pollID).Scan(&poll.ID, &creatorID, &poll.Multiple, &poll.Anonymous, &poll.ResultsVisibility, &closesAt)
+	if err != nil {
+		return nil, err
+	}
+	if closesAt.Valid {
+		poll.ClosesAt = &closesAt.Time
+		poll.Closed = !time.Now().Before(closesAt.Time)
+	}
+
+	poll.MyVotes = []string{}
+	rows, err := db.DB.Query("SELECT option_id FROM poll_votes WHERE poll_id = ? AND user_id = ?", pollID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var optionID string
+		if err := rows.Scan(&optionID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		poll.MyVotes = append(poll.MyVotes, optionID)
+	}
+	rows.Close()
+
+	switch poll.ResultsVisibility {
+	case ResultsAlways:
+		poll.ResultsVisible = true
+	case ResultsAfterVote:
+		poll.ResultsVisible = len(poll.MyVotes) > 0 || poll.Closed
+	case ResultsAfterClose:
+		poll.ResultsVisible = poll.Closed
+	}
+	if userID == creatorID {
+		poll.ResultsVisible = true
+	}
+
+	rows, err = db.DB.Query(`
+		SELECT o.id, o.text, o.position, COUNT(v.id)
+		FROM poll_options o
+		LEFT JOIN poll_votes v ON v.option_id = o.id
+		WHERE o.poll_id = ?
+		GROUP BY o.id
+		ORDER BY o.position ASC
+	`, pollID)
+	if err != nil {
+		return nil, err
+	}
+	poll.Options = []Option{}
+	for rows.Next() {
+		var option Option
+		var votes int
+		if err := rows.Scan(&option.ID, &option.Text, &option.Position, &votes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if poll.ResultsVisible {
+			option.Votes = &votes
+		}
+		poll.Options = append(poll.Options, option)
+	}
+	rows.Close()
+
+	if !poll.ResultsVisible {
+		return &poll, nil
+	}
+	var total int
+	err = db.DB.QueryRow("SELECT COUNT(DISTINCT user_id) FROM poll_votes WHERE poll_id = ?", pollID).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+	poll.TotalVoters = &total
+
+	if !poll.Anonymous {
+		for i := range poll.Options {
+			voters, err := voterNames(poll.Options[i].ID)
+			if err != nil {
+				return nil, err
+			}
+			poll.Options[i].Voters = voters
+		}
+	}
+	return &poll, nil
+}
+
+func voterNames(optionID string) ([]string, error) {
+	rows, err := db.DB.Query(`
+		SELECT u.username FROM poll_votes v
+		JOIN users u ON v.user_id = u.id
+		WHERE v.option_id = ?
+		ORDER BY v.created_at ASC
+	`, optionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	voters := []string{}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		voters = append(voters, username)
+	}
+	return voters, rows.Err()
+}
+
+func canSee(w http.ResponseWriter, userID, pollID string) bool {
+	var targetType, targetID string
+	err := db.DB.QueryRow("SELECT target_type, target_id FROM polls WHERE id = ?", pollID).Scan(&targetType, &targetID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Poll not found", http.StatusNotFound)
+			return false
+		}
+		logger.LogError("Error fetching poll", err)
+		http.Error(w, "Error fetching poll", http.StatusInternalServerError)
+		return false
+	}
+	check, ok := accessChecks[targetType]
+	if !ok || !check(userID, targetID) {
+		http.Error(w, "Unauthorized: You cannot access this poll", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func GetPoll(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	pollID := r.URL.Query().Get("poll_id")
+	if pollID == "" {
+		http.Error(w, "Missing poll_id parameter", http.StatusBadRequest)
+		return
+	}
+	if !canSee(w, userID, pollID) {
+		return
+	}
+
+	poll, err := load(userID, pollID)
+	if err != nil {
+		logger.LogError("Error loading poll", err)
+		http.Error(w, "Error loading poll", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(poll)
+}
+
+// Vote replaces the caller's votes on a poll, so voting again changes the
+// previous choice.
+func Vote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var request VoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.PollID == "" {
+		http.Error(w, "Missing poll_id", http.StatusBadRequest)
+		return
+	}
+	if !canSee(w, userID, request.PollID) {
+		return
+	}
+
+	var multiple bool
+	var closesAt sql.NullTime
+	err = db.DB.QueryRow("SELECT multiple, closes_at FROM polls WHERE id = ?", request.PollID).Scan(&multiple, &closesAt)
+	if err != nil {
+		logger.LogError("Error fetching poll", err)
+		http.Error(w, "Error fetching poll", http.StatusInternalServerError)
+		return
+	}
+	if closesAt.Valid && !time.Now().Before(closesAt.Time) {
+		http.Error(w, "This poll is closed", http.StatusBadRequest)
+		return
+	}
+
+	seen := map[string]bool{}
+	var optionIDs []string
+	for _, optionID := range request.OptionIDs {
+		if optionID != "" && !seen[optionID] {
+			seen[optionID] = true
+			optionIDs = append(optionIDs, optionID)
+		}
+	}
+	if len(optionIDs) == 0 {
+		http.Error(w, "Choose at least one option", http.StatusBadRequest)
+		return
+	}
+	if !multiple && len(optionIDs) > 1 {
+		http.Error(w, "This poll allows a single choice", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM poll_votes WHERE poll_id = ? AND user_id = ?", request.PollID, userID); err != nil {
+		logger.LogError("Error clearing votes", err)
+		http.Error(w, "Error saving vote", http.StatusInternalServerError)
+		return
+	}
+	for _, optionID := range optionIDs {
+		var exists bool
+		err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM poll_options WHERE id = ? AND poll_id = ?)", optionID, request.PollID).Scan(&exists)
+		if err != nil {
+			logger.LogError("Error checking poll option", err)
+			http.Error(w, "Error saving vote", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "Invalid option", http.StatusBadRequest)
+			return
+		}
+		voteID, err := uuid.NewV7()
+		if err != nil {
+			http.Error(w, "Failed to generate vote ID", http.StatusInternalServerError)
+			return
+		}
+		_, err = tx.Exec("INSERT INTO poll_votes (id, poll_id, option_id, user_id, created_at) VALUES (?, ?, ?, ?, ?)",
+			voteID.String(), request.PollID, optionID, userID, time.Now())
+		if err != nil {
+			logger.LogError("Error saving vote", err)
+			http.Error(w, "Error saving vote", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	poll, err := load(userID, request.PollID)
+	if err != nil {
+		logger.LogError("Error loading poll", err)
+		http.Error(w, "Error loading poll", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(poll)
+}
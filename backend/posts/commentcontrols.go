@@ -0,0 +1,164 @@
+package posts
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/session"
+)
+
+// Who can comment on a post, among the people who can see it.
+const (
+	CommentsEveryone  = "everyone"
+	CommentsFollowers = "followers"
+	CommentsMentioned = "mentioned"
+	CommentsNobody    = "nobody"
+)
+
+type CommentControlsRequest struct {
+	PostID        string `json:"post_id"`
+	CommentPolicy string `json:"comment_policy"`
+	Locked        *bool  `json:"locked"`
+}
+
+// parseCommentPolicy normalizes a policy from a request; empty means
+// everyone.
+func parseCommentPolicy(policy string) (string, bool) {
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	switch policy {
+	case "":
+		return CommentsEveryone, true
+	case CommentsEveryone, CommentsFollowers, CommentsMentioned, CommentsNobody:
+		return policy, true
+	}
+	return "", false
+}
+
+// CommentSettings returns who may comment on a post and whether its
+// comments are locked.
+func CommentSettings(postID string) (string, bool, error) {
+	var policy string
+	var locked bool
+	err := db.DB.QueryRow("SELECT comment_policy, comments_locked FROM posts WHERE id = ?", postID).Scan(&policy, &locked)
+	return policy, locked, err
+}
+
+// CanComment decides whether userID, who can already see postID, may add a
+// comment to it. The author can always comment on their own post. When the
+// answer is no, the reason can be shown to the user.
+func CanComment(userID string, postID string) (bool, string) {
+	var ownerID, policy string
+	var locked bool
+	err := db.DB.QueryRow("SELECT user_id, comment_policy, comments_locked FROM posts WHERE id = ?", postID).Scan(&ownerID, &policy, &locked)
+	if err != nil {
+		return false, "Post not found"
+	}
+	if userID == ownerID {
+		return true, ""
+	}
+	if locked {
+		return false, "Comments on this post are locked"
+	}
+
+	var allowed bool
+	switch policy {
+	case CommentsEveryone:
+		return true, ""
+	case CommentsFollowers:
+		err = db.DB.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM Followers WHERE follower_id = ? AND followed_id = ? AND status = 'accepted')
+		`, userID, ownerID).Scan(&allowed)
+		if err == nil && !allowed {
+			return false, "Only followers of the author can comment on this post"
+		}
+	case CommentsMentioned:
+		err = db.DB.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM mentions WHERE target_type = 'post' AND target_id = ? AND mentioned_user_id = ?)
+		`, postID, userID).Scan(&allowed)
+		if err == nil && !allowed {
+			return false, "Only people mentioned in this post can comment on it"
+		}
+	default:
+		return false, "Comments are turned off for this post"
+	}
+	if err != nil {
+		logger.LogError("Error checking comment permission", err)
+		return false, "Error checking comment permission"
+	}
+	return true, ""
+}
+
+// SetCommentControls lets the author change who can comment on a post and
+// lock or unlock its comments. Fields left out of the request are kept.
+func SetCommentControls(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokene, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(tokene.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var request CommentControlsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.PostID == "" {
+		http.Error(w, "Missing post_id", http.StatusBadRequest)
+		return
+	}
+	if !checkPostOwner(w, userID, request.PostID) {
+		return
+	}
+
+	policy, locked, err := CommentSettings(request.PostID)
+	if err != nil {
+		logger.LogError("Error fetching comment settings", err)
+		http.Error(w, "Error fetching post", http.StatusInternalServerError)
+		return
+	}
+	if request.CommentPolicy != "" {
+		if policy, ok = parseCommentPolicy(request.CommentPolicy); !ok {
+			http.Error(w, "Invalid comment policy", http.StatusBadRequest)
+			return
+		}
+	}
+	if request.Locked != nil {
+		locked = *request.Locked
+	}
+
+	_, err = db.DB.Exec("UPDATE posts SET comment_policy = ?, comments_locked = ? WHERE id = ?", policy, locked, request.PostID)
+	if err != nil {
+		logger.LogError("Error updating comment settings", err)
+		http.Error(w, "Error updating comment settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":         "Comment settings updated successfully",
+		"comment_policy":  policy,
+		"comments_locked": locked,
+	})
+}
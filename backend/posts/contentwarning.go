@@ -0,0 +1,86 @@
+package posts
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"social-net/db"
+	"social-net/filters"
+	logger "social-net/log"
+	"social-net/moderation"
+	"social-net/session"
+	"social-net/warnings"
+)
+
+type ContentWarningRequest struct {
+	PostID         string `json:"post_id"`
+	ContentWarning string `json:"content_warning"`
+	Sensitive      bool   `json:"sensitive"`
+}
+
+// SetContentWarning changes the warning and sensitive flag of a post without
+// counting as an edit; it doesn't touch the content or its history.
+func SetContentWarning(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokene, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(tokene.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var request ContentWarningRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.PostID == "" {
+		http.Error(w, "Missing post_id", http.StatusBadRequest)
+		return
+	}
+	request.ContentWarning = strings.TrimSpace(request.ContentWarning)
+	if len([]rune(request.ContentWarning)) > warnings.MaxWarningLength {
+		http.Error(w, fmt.Sprintf("Content warning must not exceed %d characters", warnings.MaxWarningLength), http.StatusBadRequest)
+		return
+	}
+	if !checkPostOwner(w, userID, request.PostID) {
+		return
+	}
+	verdict, ok := filters.Apply(w, "", &request.ContentWarning)
+	if !ok {
+		return
+	}
+
+	_, err = db.DB.Exec("UPDATE posts SET content_warning = ?, sensitive = ? WHERE id = ?",
+		request.ContentWarning, request.Sensitive, request.PostID)
+	if err != nil {
+		logger.LogError("Error updating content warning", err)
+		http.Error(w, "Error updating post", http.StatusInternalServerError)
+		return
+	}
+	if err := verdict.Flag(db.DB, moderation.TargetPost, request.PostID, ""); err != nil {
+		logger.LogError("Error flagging post", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Content warning updated successfully"})
+}
@@ -0,0 +1,335 @@
+package posts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"social-net/attachments"
+	"social-net/db"
+	"social-net/filters"
+	logger "social-net/log"
+	"social-net/moderation"
+	"social-net/session"
+)
+
+const (
+	StateDraft     = "draft"
+	StateScheduled = "scheduled"
+	StatePublished = "published"
+)
+
+type Draft struct {
+	Id            string                   `json:"id"`
+	Title         string                   `json:"title"`
+	Content       string                   `json:"content"`
+	Image         string                   `json:"image"`
+	Status        string                   `json:"status"`
+	State         string                   `json:"state"`
+	PublishAt     string                   `json:"publish_at"`
+	Creation_date string                   `json:"creation_date"`
+	Attachments   []attachments.Attachment `json:"attachments"`
+}
+
+var publishHooks []func(postID string)
+
+// OnPublish registers a side effect to run whenever a post becomes visible,
+// whether it was published immediately or by the scheduler.
+func OnPublish(hook func(postID string)) {
+	publishHooks = append(publishHooks, hook)
+}
+
+func runPublishHooks(postID string) {
+	for _, hook := range publishHooks {
+		hook(postID)
+	}
+}
+
+// parseState reads the requested state and publish_at form values. Scheduled
+// posts need a publish_at in the future (RFC 3339); it is stored in UTC so the
+// scheduler can compare it directly.
+func parseState(state, publishAt string) (string, interface{}, error) {
+	state = strings.ToLower(strings.TrimSpace(state))
+	if state == "" {
+		state = StatePublished
+	}
+	switch state {
+	case StatePublished, StateDraft:
+		return state, nil, nil
+	case StateScheduled:
+		at, err := time.Parse(time.RFC3339, strings.TrimSpace(publishAt))
+		if err != nil {
+			return "", nil, fmt.Errorf("publish_at must be an RFC 3339 time")
+		}
+		if !at.After(time.Now()) {
+			return "", nil, fmt.Errorf("publish_at must be in the future")
+		}
+		return state, at.UTC(), nil
+	}
+	return "", nil, fmt.Errorf("invalid post state")
+}
+
+func GetDrafts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	tokene, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(tokene.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT id, title, content, image, status, state, publish_at, creation_date
+		FROM posts
+		WHERE user_id = ? AND state != 'published'
+		ORDER BY state ASC, publish_at ASC, creation_date DESC
+	`, userID)
+	if err != nil {
+		logger.LogError("Error fetching drafts", err)
+		http.Error(w, "Error fetching drafts", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	drafts := []Draft{}
+	for rows.Next() {
+		var draft Draft
+		var publishAt sql.NullString
+		err := rows.Scan(&draft.Id, &draft.Title, &draft.Content, &draft.Image, &draft.Status, &draft.State, &publishAt, &draft.Creation_date)
+		if err != nil {
+			logger.LogError("Error scanning draft", err)
+			http.Error(w, "Error scanning draft", http.StatusInternalServerError)
+			return
+		}
+		draft.PublishAt = publishAt.String
+		if draft.Image != "" {
+			draft.Image = "http://20.56.138.63:8080/uploads/" + draft.Image
+		}
+		draft.Attachments, err = attachments.List(attachments.TargetPost, draft.Id)
+		if err != nil {
+			logger.LogError("Error fetching attachments", err)
+		}
+		drafts = append(drafts, draft)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drafts)
+}
+
+func EditDraft(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "PATCH, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokene, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(tokene.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	postID := r.URL.Query().Get("post_id")
+	if postID == "" {
+		http.Error(w, "Missing post_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	err = r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	if !checkPostOwner(w, userID, postID) {
+		return
+	}
+
+	var title, content, image, state, contentWarning string
+	var publishAt sql.NullTime
+	err = db.DB.QueryRow("SELECT title, content, image, state, publish_at, content_warning FROM posts WHERE id = ?", postID).
+		Scan(&title, &content, &image, &state, &publishAt, &contentWarning)
+	if err != nil {
+		logger.LogError("Error fetching draft", err)
+		http.Error(w, "Error fetching draft", http.StatusInternalServerError)
+		return
+	}
+	if state == StatePublished {
+		http.Error(w, "Post is already published", http.StatusBadRequest)
+		return
+	}
+
+	if newTitle := strings.TrimSpace(r.FormValue("title")); newTitle != "" {
+		title = newTitle
+	}
+	if newContent := strings.TrimSpace(r.FormValue("content")); newContent != "" {
+		content = newContent
+	}
+	if len(title) > 100 {
+		http.Error(w, "Title must not exceed 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(content) > 1000 {
+		http.Error(w, "Content must not exceed 1000 characters", http.StatusBadRequest)
+		return
+	}
+
+	// written back as a time, like parseState does, so the stored text keeps
+	// the format the scheduler compares against
+	var newPublishAt interface{}
+	if publishAt.Valid {
+		newPublishAt = publishAt.Time.UTC()
+	}
+	if r.FormValue("state") != "" {
+		state, newPublishAt, err = parseState(r.FormValue("state"), r.FormValue("publish_at"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// checked again here since the scheduler publishes without looking
+	verdict, ok := filters.Apply(w, "", &title, &content, &contentWarning)
+	if !ok {
+		return
+	}
+
+	uploads, ok := attachments.SaveUploads(w, r, "")
+	if !ok {
+		return
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			attachments.Discard(uploads)
+		}
+	}()
+	removeImages := r.FormValue("remove_image") == "true"
+	if len(uploads) > 0 {
+		image = uploads[0].Filename
+	} else if removeImages {
+		image = ""
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE posts SET title = ?, content = ?, image = ?, content_warning = ?, state = ?, publish_at = ?, creation_date = ? WHERE id = ?",
+		title, content, image, contentWarning, state, newPublishAt, time.Now(), postID)
+	if err != nil {
+		logger.LogError("Error updating draft", err)
+		http.Error(w, "Error updating draft", http.StatusInternalServerError)
+		return
+	}
+
+	if err := verdict.Flag(tx, moderation.TargetPost, postID, ""); err != nil {
+		logger.LogError("Error flagging draft", err)
+		http.Error(w, "Error updating draft", http.StatusInternalServerError)
+		return
+	}
+
+	if len(uploads) > 0 || removeImages {
+		if err := attachments.Delete(tx, attachments.TargetPost, postID); err != nil {
+			logger.LogError("Error removing attachments", err)
+			http.Error(w, "Error updating draft", http.StatusInternalServerError)
+			return
+		}
+		if err := attachments.Insert(tx, attachments.TargetPost, postID, uploads); err != nil {
+			logger.LogError("Error saving attachments", err)
+			http.Error(w, "Error updating draft", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	committed = true
+
+	message := "Draft updated successfully"
+	if state == StatePublished {
+		runPublishHooks(postID)
+		message = "Post published successfully"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message, "state": state})
+}
+
+// StartScheduler publishes scheduled posts once their publish_at has passed.
+func StartScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			publishDuePosts(time.Now())
+		}
+	}()
+}
+
+// publishDuePosts publishes every scheduled post whose publish_at is at or
+// before now.
+func publishDuePosts(now time.Time) {
+	rows, err := db.DB.Query("SELECT id FROM posts WHERE state = 'scheduled' AND publish_at <= ?", now.UTC())
+	if err != nil {
+		logger.LogError("Error fetching scheduled posts", err)
+		return
+	}
+	var due []string
+	for rows.Next() {
+		var postID string
+		if err := rows.Scan(&postID); err != nil {
+			logger.LogError("Error scanning scheduled post", err)
+			continue
+		}
+		due = append(due, postID)
+	}
+	rows.Close()
+
+	for _, postID := range due {
+		result, err := db.DB.Exec("UPDATE posts SET state = 'published', creation_date = ? WHERE id = ? AND state = 'scheduled'",
+			time.Now(), postID)
+		if err != nil {
+			logger.LogError("Error publishing scheduled post", err)
+			continue
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			runPublishHooks(postID)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package posts
+
+import (
+	"bytes"
+	"database/sql"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"social-net/db"
+
+	_ "github.com/mattn/go-sqlite3"
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+// useTestDB points db.DB at a fresh in-memory database with every migration
+// applied, and restores the previous handle when the test ends.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one connection, since every connection to :memory: is its own database
+	conn.SetMaxOpenConns(1)
+	migrations := &migrate.FileMigrationSource{Dir: "../db/migrations/sqlite3"}
+	if _, err := migrate.Exec(conn, "sqlite3", migrations, migrate.Up); err != nil {
+		t.Fatalf("migrating test database: %v", err)
+	}
+	previous := db.DB
+	db.DB = conn
+	t.Cleanup(func() {
+		db.DB = previous
+		conn.Close()
+	})
+}
+
+// addTestUser creates a user with a live session and returns the session
+// token.
+func addTestUser(t *testing.T, id string) string {
+	t.Helper()
+	_, err := db.DB.Exec(`INSERT INTO users (id, username, email, password, first_name, last_name, date_of_birth)
+		VALUES (?, ?, ?, 'x', 'First', 'Last', '2000-01-01')`, id, id, id+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := "token-" + id
+	_, err = db.DB.Exec("INSERT INTO sessions (session_id, user_id, token, expires_at) VALUES (?, ?, ?, ?)",
+		"session-"+id, id, token, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func postState(t *testing.T, postID string) string {
+	t.Helper()
+	var state string
+	if err := db.DB.QueryRow("SELECT state FROM posts WHERE id = ?", postID).Scan(&state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestEditedScheduledDraftPublishesOnTime(t *testing.T) {
+	useTestDB(t)
+	token := addTestUser(t, "draft-owner")
+
+	publishAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	_, err := db.DB.Exec(`INSERT INTO posts (id, title, content, user_id, author, creation_date, status, image, state, publish_at)
+		VALUES ('scheduled-post', 'Old title', 'Body', 'draft-owner', 'draft-owner', ?, 'public', '', ?, ?)`, time.Now(), StateScheduled, publishAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// edit only the title, leaving state and publish_at as they were
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("title", "New title")
+	form.Close()
+	req := httptest.NewRequest(http.MethodPatch, "/api/posts/drafts/edit?post_id=scheduled-post", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.AddCookie(&http.Cookie{Name: "token", Value: token})
+	rec := httptest.NewRecorder()
+	EditDraft(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("EditDraft() status = %d: %s", rec.Code, rec.Body)
+	}
+
+	publishDuePosts(publishAt.Add(-time.Second))
+	if got := postState(t, "scheduled-post"); got != StateScheduled {
+		t.Fatalf("state a second before publish_at = %q, want %q", got, StateScheduled)
+	}
+	publishDuePosts(publishAt.Add(time.Second))
+	if got := postState(t, "scheduled-post"); got != StatePublished {
+		t.Fatalf("state a second after publish_at = %q, want %q", got, StatePublished)
+	}
+}
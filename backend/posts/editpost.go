@@ -0,0 +1,426 @@
+package posts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"social-net/attachments"
+	"social-net/audiences"
+	"social-net/bookmarks"
+	"social-net/db"
+	"social-net/filters"
+	"social-net/imaging"
+	logger "social-net/log"
+	"social-net/mentions"
+	"social-net/moderation"
+	"social-net/polls"
+	"social-net/previews"
+	"social-net/session"
+	"social-net/tags"
+	"social-net/views"
+
+	"github.com/gofrs/uuid"
+)
+
+type PostRevision struct {
+	Id          string                   `json:"id"`
+	PostId      string                   `json:"post_id"`
+	Title       string                   `json:"title"`
+	Content     string                   `json:"content"`
+	Image       string                   `json:"image"`
+	Attachments []attachments.Attachment `json:"attachments"`
+	Edited_at   string                   `json:"edited_at"`
+}
+
+func EditPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "PATCH, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokene, err := r.Cookie("token")
+	if err != nil {
+		logger.LogError("Error getting token", err)
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userid, ok := session.GetUserIDFromToken(tokene.Value)
+	if !ok || userid == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	postID := r.URL.Query().Get("post_id")
+	if postID == "" {
+		http.Error(w, "Missing post_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	err = r.ParseMultipartForm(10 << 20)
+	if err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	var ownerID, title, content, image, state, contentWarning string
+	err = db.DB.QueryRow("SELECT user_id, title, content, image, state, content_warning FROM posts WHERE id = ?", postID).
+		Scan(&ownerID, &title, &content, &image, &state, &contentWarning)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
+		logger.LogError("Error fetching post", err)
+		http.Error(w, "Error fetching post", http.StatusInternalServerError)
+		return
+	}
+	if ownerID != userid {
+		http.Error(w, "Forbidden: You can only edit your own posts", http.StatusForbidden)
+		return
+	}
+	if state != StatePublished {
+		http.Error(w, "Drafts and scheduled posts are edited through /api/posts/drafts/edit", http.StatusBadRequest)
+		return
+	}
+
+	newTitle := strings.TrimSpace(r.FormValue("title"))
+	newContent := strings.TrimSpace(r.FormValue("content"))
+	if newTitle == "" {
+		newTitle = title
+	}
+	if newContent == "" {
+		newContent = content
+	}
+
+	if len(newTitle) > 100 {
+		http.Error(w, "Title must not exceed 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(newContent) > 1000 {
+		http.Error(w, "Content must not exceed 1000 characters", http.StatusBadRequest)
+		return
+	}
+
+	verdict, ok := filters.Apply(w, "", &newTitle, &newContent, &contentWarning)
+	if !ok {
+		return
+	}
+
+	uploads, ok := attachments.SaveUploads(w, r, "")
+	if !ok {
+		return
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			attachments.Discard(uploads)
+		}
+	}()
+	removeImages := r.FormValue("remove_image") == "true"
+	newImage := image
+	if len(uploads) > 0 {
+		newImage = uploads[0].Filename
+	} else if removeImages {
+		newImage = ""
+	}
+
+	if newTitle == title && newContent == content && len(uploads) == 0 && !removeImages {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	revisionID, err := uuid.NewV7()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error generating UUID: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	editedAt := time.Now()
+	_, err = tx.Exec("INSERT INTO post_revisions (id, post_id, title, content, image, edited_at) VALUES (?, ?, ?, ?, ?, ?)",
+		revisionID.String(), postID, title, content, image, editedAt)
+	if err != nil {
+		logger.LogError("Error saving post revision", err)
+		http.Error(w, "Error saving post revision", http.StatusInternalServerError)
+		return
+	}
+	if err := attachments.Copy(tx, attachments.TargetPost, postID, attachments.TargetPostRevision, revisionID.String()); err != nil {
+		logger.LogError("Error saving revision attachments", err)
+		http.Error(w, "Error saving post revision", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec("UPDATE posts SET title = ?, content = ?, image = ?, content_warning = ?, edited_at = ? WHERE id = ?",
+		newTitle, newContent, newImage, contentWarning, editedAt, postID)
+	if err != nil {
+		logger.LogError("Error updating post", err)
+		http.Error(w, "Error updating post", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tags.Index(tx, tags.TargetPost, postID, newContent); err != nil {
+		logger.LogError("Error indexing post tags", err)
+		http.Error(w, "Error updating post", http.StatusInternalServerError)
+		return
+	}
+
+	if err := verdict.Flag(tx, moderation.TargetPost, postID, ""); err != nil {
+		logger.LogError("Error flagging post", err)
+		http.Error(w, "Error updating post", http.StatusInternalServerError)
+		return
+	}
+
+	if len(uploads) > 0 || removeImages {
+		if err := attachments.Delete(tx, attachments.TargetPost, postID); err != nil {
+			logger.LogError("Error removing attachments", err)
+			http.Error(w, "Error updating post", http.StatusInternalServerError)
+			return
+		}
+		if err := attachments.Insert(tx, attachments.TargetPost, postID, uploads); err != nil {
+			logger.LogError("Error saving attachments", err)
+			http.Error(w, "Error updating post", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	committed = true
+
+	mentions.Record(mentions.TargetPost, postID, userid, newContent)
+	go previews.Warm(newContent)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Post updated successfully"})
+}
+
+func DeletePost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokene, err := r.Cookie("token")
+	if err != nil {
+		logger.LogError("Error getting token", err)
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userid, ok := session.GetUserIDFromToken(tokene.Value)
+	if !ok || userid == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	postID := r.URL.Query().Get("post_id")
+	if postID == "" {
+		http.Error(w, "Missing post_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	if !checkPostOwner(w, userid, postID) {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	files, err := postFiles(tx, postID)
+	if err != nil {
+		logger.LogError("Error listing post images", err)
+		http.Error(w, "Error deleting post", http.StatusInternalServerError)
+		return
+	}
+
+	for _, query := range []string{
+		"DELETE FROM reactions WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE target_type = 'post' AND target_id = ?)",
+		"DELETE FROM mentions WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE target_type = 'post' AND target_id = ?)",
+		"DELETE FROM mentions WHERE target_type = 'post' AND target_id = ?",
+		"DELETE FROM reactions WHERE target_type = 'post' AND target_id = ?",
+		"DELETE FROM comments WHERE target_type = 'post' AND target_id = ?",
+		"DELETE FROM postsPrivacy WHERE post_id = ?",
+		"DELETE FROM attachments WHERE target_type = 'post_revision' AND target_id IN (SELECT id FROM post_revisions WHERE post_id = ?)",
+		"DELETE FROM post_revisions WHERE post_id = ?",
+		"DELETE FROM attachments WHERE target_type = 'post' AND target_id = ?",
+		"DELETE FROM post_tags WHERE target_type = 'post' AND target_id = ?",
+		"DELETE FROM posts WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, postID); err != nil {
+			logger.LogError("Error deleting post", err)
+			http.Error(w, "Error deleting post", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := views.DeletePost(tx, postID); err != nil {
+		logger.LogError("Error deleting post views", err)
+		http.Error(w, "Error deleting post", http.StatusInternalServerError)
+		return
+	}
+	if err := bookmarks.DeleteTarget(tx, bookmarks.TargetPost, postID); err != nil {
+		logger.LogError("Error deleting bookmarks", err)
+		http.Error(w, "Error deleting post", http.StatusInternalServerError)
+		return
+	}
+	if err := audiences.Detach(tx, postID); err != nil {
+		logger.LogError("Error deleting post audience lists", err)
+		http.Error(w, "Error deleting post", http.StatusInternalServerError)
+		return
+	}
+	if err := polls.Delete(tx, polls.TargetPost, postID); err != nil {
+		logger.LogError("Error deleting poll", err)
+		http.Error(w, "Error deleting post", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	for _, filename := range files {
+		imaging.Remove(filename)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Post deleted successfully"})
+}
+
+// postFiles lists every image stored for a post: its attachments and those
+// kept with its revisions, the legacy image columns, and its comments' images.
+func postFiles(tx *sql.Tx, postID string) ([]string, error) {
+	rows, err := tx.Query(`
+		SELECT filename FROM attachments WHERE target_type = 'post' AND target_id = ?
+		UNION SELECT filename FROM attachments WHERE target_type = 'post_revision' AND target_id IN (SELECT id FROM post_revisions WHERE post_id = ?)
+		UNION SELECT image FROM posts WHERE id = ?
+		UNION SELECT image FROM post_revisions WHERE post_id = ?
+		UNION SELECT image FROM comments WHERE target_type = 'post' AND target_id = ?
+	`, postID, postID, postID, postID, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []string
+	for rows.Next() {
+		var filename sql.NullString
+		if err := rows.Scan(&filename); err != nil {
+			return nil, err
+		}
+		if filename.String != "" {
+			files = append(files, filename.String)
+		}
+	}
+	return files, rows.Err()
+}
+
+func GetPostHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	tokene, err := r.Cookie("token")
+	if err != nil {
+		logger.LogError("Missing token", err)
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(tokene.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	postID := r.URL.Query().Get("post_id")
+	if postID == "" {
+		http.Error(w, "Missing post_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	if !CheckUserPostPermission(userID, postID) {
+		http.Error(w, "Unauthorized: You do not have permission to view this post", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT id, post_id, title, content, image, edited_at
+		FROM post_revisions
+		WHERE post_id = ?
+		ORDER BY edited_at DESC
+	`, postID)
+	if err != nil {
+		logger.LogError("Error fetching post history", err)
+		http.Error(w, "Error fetching post history", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	revisions := []PostRevision{}
+	for rows.Next() {
+		var revision PostRevision
+		err := rows.Scan(&revision.Id, &revision.PostId, &revision.Title, &revision.Content, &revision.Image, &revision.Edited_at)
+		if err != nil {
+			logger.LogError("Error scanning post revision", err)
+			http.Error(w, "Error scanning post revision", http.StatusInternalServerError)
+			return
+		}
+		if revision.Image != "" {
+			revision.Image = "http://20.56.138.63:8080/uploads/" + revision.Image
+		}
+		revisions = append(revisions, revision)
+	}
+	rows.Close()
+
+	for i := range revisions {
+		revisions[i].Attachments, err = attachments.List(attachments.TargetPostRevision, revisions[i].Id)
+		if err != nil {
+			logger.LogError("Error fetching revision attachments", err)
+			revisions[i].Attachments = []attachments.Attachment{}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
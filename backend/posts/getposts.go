@@ -1,25 +1,62 @@
 package posts
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
+	"social-net/attachments"
+	"social-net/audiences"
 	"social-net/db"
+	"social-net/filters"
+	"social-net/imaging"
 	logger "social-net/log"
+	"social-net/mentions"
+	"social-net/moderation"
+	"social-net/pagination"
+	"social-net/polls"
+	"social-net/previews"
+	"social-net/reactions"
+	"social-net/richtext"
 	"social-net/session"
+	"social-net/views"
+	"social-net/warnings"
 )
 
 type GetPost struct {
-	Id            string
-	User_id       string
-	Author        string
-	Avatar        string
-	Content       string
-	Title         string
-	Image         string
-	Creation_date string
-	Status        string
+	Id              string
+	User_id         string
+	Author          string
+	Avatar          string
+	Content         string
+	Content_spans   []mentions.Span
+	Content_html    string
+	Link_previews   []previews.Preview
+	Title           string
+	Image           string
+	Creation_date   string
+	Status          string
+	Edited          bool
+	Edited_at       string
+	Reactions       map[string]int
+	My_reaction     string
+	Attachments     []attachments.Attachment
+	Repost_of       string
+	Reposted_by     string
+	Original        *GetPost
+	Repost_count    int
+	Quote_count     int
+	Comments_count  int
+	Poll            *polls.Poll
+	Content_warning string
+	Sensitive       bool
+	Collapsed       bool
+	Media_hidden    bool
+	Comment_policy  string
+	Comments_locked bool
+	Can_comment     bool
 }
 
 func Getposts(w http.ResponseWriter, r *http.Request) {
@@ -41,21 +78,56 @@ func Getposts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = FeedChronological
+	}
+	modeFilter := "1=1"
+	var modeArgs []interface{}
+	switch mode {
+	case FeedChronological, FeedRanked:
+	case FeedFollowing:
+		modeFilter = `(p.user_id = ? OR EXISTS (
+            SELECT 1 FROM Followers fw WHERE fw.follower_id = ? AND fw.followed_id = p.user_id AND fw.status = 'accepted'))`
+		modeArgs = []interface{}{userID, userID}
+	default:
+		http.Error(w, "Invalid feed mode", http.StatusBadRequest)
+		return
+	}
+
+	// visible limits rows to published posts userID may see in this mode
+	visible := `p.state = 'published' AND (
+            p.status = 'public' OR
+            (p.status = 'semi-private' AND (pp.user_id = ? OR ` + audiences.MemberCondition + `)) OR
+            (f.follower_id = ? AND f.status = 'accepted') OR
+            p.user_id = ?) AND (p.user_id = ? OR ` + moderation.Visible(moderation.TargetPost, "p.id") + `) AND ` + modeFilter
+	visibleArgs := append([]interface{}{userID, userID, userID, userID, userID}, modeArgs...)
+	muted := filters.MutedWords(userID)
+
+	if mode == FeedRanked {
+		rankedFeed(w, userID, page, visible, visibleArgs, muted)
+		return
+	}
+
+	after, afterArgs := page.Where("p.creation_date", "p.id")
 	query := `
-        SELECT DISTINCT p.id, p.author, p.content, p.title, p.user_id, p.creation_date, p.status, u.avatar, p.Image
+        SELECT DISTINCT ` + feedColumns + `, CAST(p.creation_date AS TEXT)
         FROM posts p
         LEFT JOIN postsPrivacy pp ON p.id = pp.post_id
         LEFT JOIN Followers f ON p.user_id = f.followed_id
 		LEFT JOIN users u ON p.user_id = u.id
-        WHERE 
-            p.status = 'public' OR
-            (p.status = 'semi-private' AND pp.user_id = ?) OR
-            (f.follower_id = ? AND f.status = 'accepted') OR
-            p.user_id = ?
-        ORDER BY p.creation_date DESC
+        WHERE ` + visible + ` AND ` + after + `
+        ORDER BY p.creation_date DESC, p.id DESC
+        LIMIT ?
     `
 
-	rows, err := db.DB.Query(query, userID, userID, userID)
+	args := append(visibleArgs, afterArgs...)
+	rows, err := db.DB.Query(query, append(args, page.Fetch())...)
 	if err != nil {
 		logger.LogError("Error fetching posts", err)
 		http.Error(w, fmt.Sprintf("Error fetching posts: %v", err), http.StatusInternalServerError)
@@ -63,27 +135,295 @@ func Getposts(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	var posts []GetPost
+	posts := []GetPost{}
+	var scanned int
+	var last pagination.Cursor
 	for rows.Next() {
+		scanned++
+		if scanned > page.Limit {
+			break
+		}
 		var post GetPost
-		err := rows.Scan(&post.Id, &post.Author, &post.Content, &post.Title, &post.User_id, &post.Creation_date, &post.Status, &post.Avatar, &post.Image)
+		var editedAt, repostOf sql.NullString
+		err := rows.Scan(&post.Id, &post.Author, &post.Content, &post.Title, &post.User_id, &post.Creation_date, &post.Status, &post.Avatar, &post.Image, &editedAt, &repostOf, &post.Content_warning, &post.Sensitive, &last.Time)
 		if err != nil {
 			logger.LogError("Error scanning post", err)
 			http.Error(w, fmt.Sprintf("Error scanning post: %v", err), http.StatusInternalServerError)
 			return
 		}
-		allowed := CheckUserPostPermission(userID, post.Id)
-		if !allowed {
-			logger.LogError("Unauthorized access to post", fmt.Errorf("user %s not allowed to access post %s", userID, post.Id))
-			continue
+		last.ID = post.Id
+		post.Edited = editedAt.Valid
+		post.Edited_at = editedAt.String
+		post.Repost_of = repostOf.String
+		if feedItem(userID, &post, muted) {
+			posts = append(posts, post)
 		}
+	}
+	RecordViews(userID, posts)
 
-		if post.Image != "" {
-			post.Image = "http://20.56.138.63:8080/uploads/" + post.Image
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination.Response{
+		Items:      posts,
+		NextCursor: page.Next(scanned, last),
+	})
+}
+
+const feedColumns = `p.id, p.author, p.content, p.title, p.user_id, p.creation_date, p.status, u.avatar, p.Image, p.edited_at, p.repost_of, p.content_warning, p.sensitive`
+
+// feedItem finishes a feed row for userID and reports whether it should be
+// shown: posts they can't see, muted posts and reposts of hidden originals
+// are dropped.
+func feedItem(userID string, post *GetPost, muted []string) bool {
+	if !CheckUserPostPermission(userID, post.Id) {
+		logger.LogError("Unauthorized access to post", fmt.Errorf("user %s not allowed to access post %s", userID, post.Id))
+		return false
+	}
+	if isMuted(userID, post, muted) {
+		return false
+	}
+	fillPost(userID, post)
+
+	if post.Repost_of != "" {
+		var allowed bool
+		post.Original, allowed = LoadPost(userID, post.Repost_of)
+		if !allowed && post.Content == "" {
+			return false
+		}
+		if allowed && isMuted(userID, post.Original, muted) {
+			return false
 		}
-		posts = append(posts, post)
+		if post.Content == "" {
+			post.Reposted_by = post.Author
+		}
+	}
+	return true
+}
+
+// rankedFeed scores the newest RankCandidates posts from the RankWindow
+// before the first page was loaded, and pages through them by score. The
+// cursor keeps the time of the first page so ages, and with them scores,
+// stay put while the reader scrolls; only new engagement moves posts.
+func rankedFeed(w http.ResponseWriter, userID string, page pagination.Page, visible string, visibleArgs []interface{}, muted []string) {
+	at := time.Now().UTC()
+	if page.After != nil {
+		var err error
+		at, err = time.Parse(time.RFC3339Nano, page.After.At)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	query := `
+        SELECT DISTINCT ` + feedColumns + `,
+            (SELECT COUNT(*) FROM reactions r WHERE r.target_type = 'post' AND r.target_id = p.id),
+            (SELECT COUNT(*) FROM comments c WHERE c.target_type = 'post' AND c.target_id = p.id),
+            (SELECT COUNT(*) FROM posts rp WHERE rp.repost_of = p.id AND rp.state = 'published')
+        FROM posts p
+        LEFT JOIN postsPrivacy pp ON p.id = pp.post_id
+        LEFT JOIN Followers f ON p.user_id = f.followed_id
+		LEFT JOIN users u ON p.user_id = u.id
+        WHERE ` + visible + ` AND p.creation_date >= ? AND p.creation_date <= ?
+        ORDER BY p.creation_date DESC, p.id DESC
+        LIMIT ?
+    `
+	// creation_date is written in local time, so the bounds are too; the
+	// dates compare as text
+	args := append(visibleArgs, at.Add(-RankWindow).Local(), at.Local(), RankCandidates)
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		logger.LogError("Error fetching posts", err)
+		http.Error(w, fmt.Sprintf("Error fetching posts: %v", err), http.StatusInternalServerError)
+		return
 	}
+	defer rows.Close()
+
+	type extra struct{ editedAt, repostOf sql.NullString }
+	candidates := []GetPost{}
+	extras := map[string]extra{}
+	signals := []Signals{}
+	relationships := map[string]float64{}
+	for rows.Next() {
+		var post GetPost
+		var e extra
+		var s Signals
+		err := rows.Scan(&post.Id, &post.Author, &post.Content, &post.Title, &post.User_id, &post.Creation_date, &post.Status, &post.Avatar, &post.Image, &e.editedAt, &e.repostOf, &post.Content_warning, &post.Sensitive, &s.Reactions, &s.Comments, &s.Reposts)
+		if err != nil {
+			logger.LogError("Error scanning post", err)
+			http.Error(w, fmt.Sprintf("Error scanning post: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if created, err := time.Parse(time.RFC3339Nano, post.Creation_date); err == nil {
+			s.Age = at.Sub(created)
+		}
+		strength, ok := relationships[post.User_id]
+		if !ok {
+			strength = relationshipStrength(userID, post.User_id)
+			relationships[post.User_id] = strength
+		}
+		s.Relationship = strength
+		candidates = append(candidates, post)
+		extras[post.Id] = e
+		signals = append(signals, s)
+	}
+	rows.Close()
+
+	scores := Rank(candidates, signals, scorer)
+	start := 0
+	if page.After != nil {
+		for start < len(candidates) && (scores[start] > page.After.Score ||
+			scores[start] == page.After.Score && candidates[start].Id >= page.After.ID) {
+			start++
+		}
+	}
+
+	posts := []GetPost{}
+	var scanned int
+	last := pagination.Cursor{At: at.Format(time.RFC3339Nano)}
+	for i := start; i < len(candidates); i++ {
+		scanned++
+		if scanned > page.Limit {
+			break
+		}
+		post := candidates[i]
+		last.ID, last.Score = post.Id, scores[i]
+		e := extras[post.Id]
+		post.Edited = e.editedAt.Valid
+		post.Edited_at = e.editedAt.String
+		post.Repost_of = e.repostOf.String
+		if feedItem(userID, &post, muted) {
+			posts = append(posts, post)
+		}
+	}
+	RecordViews(userID, posts)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(posts)
+	json.NewEncoder(w).Encode(pagination.Response{
+		Items:      posts,
+		NextCursor: page.Next(scanned, last),
+	})
+}
+
+// isMuted reports whether a post someone else wrote contains one of the
+// viewer's muted words.
+func isMuted(userID string, post *GetPost, muted []string) bool {
+	if len(muted) == 0 || post.User_id == userID {
+		return false
+	}
+	return filters.Contains(post.Title+"\n"+post.Content+"\n"+post.Content_warning, muted)
+}
+
+func fillPost(userID string, post *GetPost) {
+	var err error
+	post.Content_spans = mentions.Spans(post.Content)
+	post.Content_html = richtext.Render(post.Content)
+	post.Link_previews = previews.ForText(post.Content)
+	post.Reactions, post.My_reaction, err = reactions.Summary(userID, reactions.TargetPost, post.Id)
+	if err != nil {
+		logger.LogError("Error counting reactions", err)
+	}
+
+	post.Collapsed, post.Media_hidden = warnings.Apply(warnings.Preference(userID), post.Content_warning, post.Sensitive)
+	if post.Media_hidden {
+		post.Image = ""
+		post.Attachments = []attachments.Attachment{}
+	} else {
+		if post.Image != "" {
+			post.Image = imaging.URL(post.Image, imaging.SizeMedium)
+		}
+		post.Attachments, err = attachments.List(attachments.TargetPost, post.Id)
+		if err != nil {
+			logger.LogError("Error fetching attachments", err)
+		}
+	}
+	post.Repost_count, post.Quote_count, err = RepostCounts(post.Id)
+	if err != nil {
+		logger.LogError("Error counting reposts", err)
+	}
+	err = db.DB.QueryRow("SELECT COUNT(*) FROM comments WHERE target_type = 'post' AND target_id = ?", post.Id).Scan(&post.Comments_count)
+	if err != nil {
+		logger.LogError("Error counting comments", err)
+	}
+	post.Comment_policy, post.Comments_locked, err = CommentSettings(post.Id)
+	if err != nil {
+		logger.LogError("Error fetching comment settings", err)
+	}
+	post.Can_comment, _ = CanComment(userID, post.Id)
+	post.Poll, err = polls.ForTarget(userID, polls.TargetPost, post.Id)
+	if err != nil {
+		logger.LogError("Error fetching poll", err)
+	}
+}
+
+// RecordViews counts an impression for every post shown.
+func RecordViews(userID string, posts []GetPost) {
+	seen := make(map[string]string, len(posts))
+	for _, post := range posts {
+		postID, authorID := ViewOf(post.Id, post.User_id, post.Content, post.Original)
+		seen[postID] = authorID
+	}
+	views.Record(userID, seen)
+}
+
+// ViewOf returns the post and author a listed post counts a view for. A plain
+// repost has nothing of its own to see, so the view goes to the original.
+func ViewOf(postID, authorID, content string, original *GetPost) (string, string) {
+	if original != nil && content == "" {
+		return original.Id, original.User_id
+	}
+	return postID, authorID
+}
+
+func relationshipStrength(viewerID, authorID string) float64 {
+	if viewerID == authorID {
+		return Relationship(true, false, false, 0)
+	}
+	var follows, followedBack bool
+	var interactions int
+	err := db.DB.QueryRow(`
+		SELECT
+			EXISTS(SELECT 1 FROM Followers WHERE follower_id = ? AND followed_id = ? AND status = 'accepted'),
+			EXISTS(SELECT 1 FROM Followers WHERE follower_id = ? AND followed_id = ? AND status = 'accepted'),
+			(SELECT COUNT(*) FROM reactions r JOIN posts p ON r.target_type = 'post' AND r.target_id = p.id
+				WHERE r.user_id = ? AND p.user_id = ? AND r.created_at >= ?) +
+			(SELECT COUNT(*) FROM comments c JOIN posts p ON c.target_type = 'post' AND c.target_id = p.id JOIN users u ON c.author = u.username
+				WHERE u.id = ? AND p.user_id = ? AND c.creation_date >= ?)
+	`, viewerID, authorID, authorID, viewerID,
+		viewerID, authorID, time.Now().AddDate(0, 0, -30),
+		viewerID, authorID, time.Now().AddDate(0, 0, -30)).Scan(&follows, &followedBack, &interactions)
+	if err != nil {
+		logger.LogError("Error computing relationship strength", err)
+		return 0
+	}
+	return Relationship(false, follows, followedBack, interactions)
+}
+
+// LoadPost returns a single published post as userID sees it, or false when
+// the post is gone or hidden from them. The original of a quote isn't loaded
+// so nested quotes stay one level deep.
+func LoadPost(userID, postID string) (*GetPost, bool) {
+	if !CheckUserPostPermission(userID, postID) {
+		return nil, false
+	}
+	var post GetPost
+	var editedAt, repostOf sql.NullString
+	var avatar sql.NullString
+	err := db.DB.QueryRow(`
+		SELECT p.id, p.author, p.content, p.title, p.user_id, p.creation_date, p.status, u.avatar, p.image, p.edited_at, p.repost_of, p.content_warning, p.sensitive
+		FROM posts p
+		LEFT JOIN users u ON p.user_id = u.id
+		WHERE p.id = ? AND p.state = 'published'
+	`, postID).Scan(&post.Id, &post.Author, &post.Content, &post.Title, &post.User_id, &post.Creation_date, &post.Status, &avatar, &post.Image, &editedAt, &repostOf, &post.Content_warning, &post.Sensitive)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.LogError("Error fetching post", err)
+		}
+		return nil, false
+	}
+	post.Avatar = avatar.String
+	post.Edited = editedAt.Valid
+	post.Edited_at = editedAt.String
+	post.Repost_of = repostOf.String
+	fillPost(userID, &post)
+	return &post, true
 }
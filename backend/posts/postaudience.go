@@ -0,0 +1,252 @@
+package posts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"social-net/audiences"
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/session"
+
+	"github.com/gofrs/uuid"
+)
+
+type AudienceRequest struct {
+	PostID       string `json:"post_id"`
+	AllowedUsers string `json:"allowed_users"`
+}
+
+func ResolveAudience(allowedUsers string) ([]string, error) {
+	seen := make(map[string]bool)
+	var userIDs []string
+	for _, user := range strings.Split(allowedUsers, ",") {
+		user = strings.TrimSpace(user)
+		if user == "" {
+			continue
+		}
+		userID, err := session.GetUserIDFromUsername(user)
+		if err != nil {
+			return nil, err
+		}
+		if userID == "" {
+			return nil, fmt.Errorf("user %s not found", user)
+		}
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+func addAudience(tx *sql.Tx, postID string, userIDs []string) error {
+	for _, userID := range userIDs {
+		privacyID, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT OR IGNORE INTO postsPrivacy (id, post_id, user_id) VALUES (?, ?, ?)",
+			privacyID.String(), postID, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeAudience(tx *sql.Tx, postID string, userIDs []string) error {
+	for _, userID := range userIDs {
+		_, err := tx.Exec("DELETE FROM postsPrivacy WHERE post_id = ? AND user_id = ?", postID, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetPostAudience(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	tokene, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(tokene.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	postID := r.URL.Query().Get("post_id")
+	if postID == "" {
+		http.Error(w, "Missing post_id parameter", http.StatusBadRequest)
+		return
+	}
+	if !checkPostOwner(w, userID, postID) {
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT u.username
+		FROM postsPrivacy pp
+		JOIN users u ON pp.user_id = u.id
+		WHERE pp.post_id = ?
+		ORDER BY u.username
+	`, postID)
+	if err != nil {
+		logger.LogError("Error fetching post audience", err)
+		http.Error(w, "Error fetching post audience", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	usernames := []string{}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			logger.LogError("Error scanning post audience", err)
+			http.Error(w, "Error scanning post audience", http.StatusInternalServerError)
+			return
+		}
+		usernames = append(usernames, username)
+	}
+
+	lists, err := audiences.ForPost(postID)
+	if err != nil {
+		logger.LogError("Error fetching post audience lists", err)
+		http.Error(w, "Error fetching post audience", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"post_id":        postID,
+		"allowed_users":  usernames,
+		"audience_lists": lists,
+	})
+}
+
+func AddPostAudience(w http.ResponseWriter, r *http.Request) {
+	editPostAudience(w, r, addAudience)
+}
+
+func RemovePostAudience(w http.ResponseWriter, r *http.Request) {
+	editPostAudience(w, r, removeAudience)
+}
+
+func editPostAudience(w http.ResponseWriter, r *http.Request, apply func(*sql.Tx, string, []string) error) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokene, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(tokene.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var request AudienceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.PostID == "" {
+		http.Error(w, "Missing post_id", http.StatusBadRequest)
+		return
+	}
+	if !checkPostOwner(w, userID, request.PostID) {
+		return
+	}
+
+	var status string
+	err = db.DB.QueryRow("SELECT status FROM posts WHERE id = ?", request.PostID).Scan(&status)
+	if err != nil {
+		logger.LogError("Error fetching post status", err)
+		http.Error(w, "Error fetching post", http.StatusInternalServerError)
+		return
+	}
+	if status != "semi-private" {
+		http.Error(w, "Only semi-private posts have an audience", http.StatusBadRequest)
+		return
+	}
+
+	audience, err := ResolveAudience(request.AllowedUsers)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusBadRequest)
+		return
+	}
+	if len(audience) == 0 {
+		http.Error(w, "Missing allowed_users", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := apply(tx, request.PostID, audience); err != nil {
+		logger.LogError("Error updating post audience", err)
+		http.Error(w, "Error updating post audience", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Post audience updated successfully"})
+}
+
+func checkPostOwner(w http.ResponseWriter, userID, postID string) bool {
+	var ownerID string
+	err := db.DB.QueryRow("SELECT user_id FROM posts WHERE id = ?", postID).Scan(&ownerID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return false
+		}
+		logger.LogError("Error fetching post", err)
+		http.Error(w, "Error fetching post", http.StatusInternalServerError)
+		return false
+	}
+	if ownerID != userID {
+		http.Error(w, "Forbidden: You can only manage your own posts", http.StatusForbidden)
+		return false
+	}
+	return true
+}
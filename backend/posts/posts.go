@@ -3,19 +3,21 @@ package posts
 import (
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
+	"social-net/attachments"
+	"social-net/audiences"
 	"social-net/auth"
 	"social-net/db"
+	"social-net/filters"
 	logger "social-net/log"
+	"social-net/moderation"
+	"social-net/polls"
 
 	"social-net/session"
+	"social-net/warnings"
 
 	"github.com/gofrs/uuid"
 )
@@ -28,6 +30,9 @@ type Posts struct {
 	Creation_date string `json:"creation_date"`
 	Status        string `json:"status"`
 	AllowedUsers  string `json:"allowed_users"`
+	AudienceLists string `json:"audience_lists"`
+	State         string `json:"state"`
+	PublishAt     string `json:"publish_at"`
 }
 
 func Post(w http.ResponseWriter, r *http.Request) {
@@ -66,6 +71,8 @@ func Post(w http.ResponseWriter, r *http.Request) {
 		post.Content = r.FormValue("content")
 		post.Status = r.FormValue("status")
 		post.AllowedUsers = r.FormValue("allowed_users")
+		post.AudienceLists = r.FormValue("audience_lists")
+		post.PublishAt = r.FormValue("publish_at")
 		post.Image = ""
 
 		if post.Title == "" || post.Content == "" {
@@ -103,112 +110,142 @@ func Post(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Unauthorized: User not found", http.StatusUnauthorized)
 			return
 		}
-		var postID string
+		post.Status = strings.ToLower(strings.TrimSpace(post.Status))
+		if post.Status != "public" && post.Status != "private" && post.Status != "semi-private" {
+			http.Error(w, "Invalid post status", http.StatusBadRequest)
+			return
+		}
 
-		file, handler, err := r.FormFile("image")
-		if err == nil && file != nil {
-			defer file.Close()
+		state, publishAt, err := parseState(r.FormValue("state"), post.PublishAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-			if handler.Size > 2*1024*1024 {
-				http.Error(w, "image file too large", http.StatusBadRequest)
-				return
-			}
+		contentWarning, sensitive, err := warnings.FromForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-			buff := make([]byte, 512)
-			_, _ = file.Read(buff)
-			contentType := http.DetectContentType(buff)
-			file.Seek(0, io.SeekStart)
-
-			allowedTypes := []string{"image/jpeg", "image/png", "image/gif"}
-			valid := false
-			for _, t := range allowedTypes {
-				if t == contentType {
-					valid = true
-					break
-				}
-			}
-			if !valid {
-				http.Error(w, "Invalid image file type", http.StatusBadRequest)
-				return
-			}
+		commentPolicy, ok := parseCommentPolicy(r.FormValue("comment_policy"))
+		if !ok {
+			http.Error(w, "Invalid comment policy", http.StatusBadRequest)
+			return
+		}
 
-			ext := filepath.Ext(handler.Filename)
-			safeFilename := fmt.Sprintf("%s_%d%s", post.Image, time.Now().Unix(), ext)
+		poll, err := polls.FromForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-			path := "./uploads"
-			_, err := os.Stat(path)
-			if os.IsNotExist(err) {
-				os.Mkdir(path, os.ModePerm)
-			}
-			savePath := filepath.Join(path, safeFilename)
+		verdict, ok := filters.Apply(w, "", &post.Title, &post.Content, &contentWarning)
+		if !ok {
+			return
+		}
 
-			out, err := os.Create(savePath)
-			if err != nil {
-				log.Println("Failed to save image:", err)
-				http.Error(w, "Failed to save image", http.StatusInternalServerError)
-				return
-			}
-			defer out.Close()
-			_, err = io.Copy(out, file)
+		var audience, lists []string
+		if post.Status == "semi-private" {
+			audience, err = ResolveAudience(post.AllowedUsers)
 			if err != nil {
-				log.Println("Failed to write image:", err)
-				http.Error(w, "Failed to write image", http.StatusInternalServerError)
+				auth.Senddata(w, 2, "User not found", http.StatusBadRequest)
 				return
 			}
-			post.Image = safeFilename
-		}
-		if strings.ToLower(post.Status) == "public" || strings.ToLower(post.Status) == "private" {
-
-			uuidV7, err := uuid.NewV7()
+			lists, err = audiences.Owned(userid, post.AudienceLists)
 			if err != nil {
-				http.Error(w, fmt.Sprintf("Error generating UUID: %v", err), http.StatusInternalServerError)
+				http.Error(w, "Audience list not found", http.StatusBadRequest)
 				return
 			}
-			postID = uuidV7.String()
-			_, err = db.DB.Exec("INSERT INTO posts (id, title, content, user_id, author, creation_date, status,image) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
-				postID, post.Title, post.Content, userid, author, time.Now(), post.Status, post.Image)
-			if err != nil {
-				fmt.Println("Error inserting post:", err)
-				http.Error(w, fmt.Sprintf("Error inserting post: %v", err), http.StatusInternalServerError)
+			if len(audience) == 0 && len(lists) == 0 {
+				http.Error(w, "Semi-private posts need at least one allowed user or audience list", http.StatusBadRequest)
 				return
 			}
+		} else if strings.TrimSpace(post.AudienceLists) != "" {
+			http.Error(w, "Only semi-private posts can target audience lists", http.StatusBadRequest)
+			return
 		}
-		if post.Status == "semi-private" {
-			var userSlice []string
-			fmt.Println("", post.AllowedUsers)
-			for _, user := range strings.Split(post.AllowedUsers, ",") {
-				sessionid, err := session.GetUserIDFromUsername(strings.TrimSpace(user))
-				if err != nil {
-					auth.Senddata(w, 2, "User not found", 400)
-					return
-				}
-				if sessionid == "" {
-					auth.Senddata(w, 2, "User not found", http.StatusBadRequest)
-					return
-				}
-				userSlice = append(userSlice, sessionid)
-			}
-			for _, user := range userSlice {
-				postID, _ := uuid.NewV7()
-
-				_, err = db.DB.Exec("INSERT INTO posts (id, title, content, user_id, author, creation_date, status,image) VALUES (?,?,?,?,?,?,?,?)",
-					postID, post.Title, post.Content, userid, author, time.Now(), post.Status, post.Image)
-				if err != nil {
-					http.Error(w, fmt.Sprintf("Error inserting post: %v", err), http.StatusInternalServerError)
-					return
-				}
-				privacyID, _ := uuid.NewV7()
-
-				_, err = db.DB.Exec("INSERT INTO postsPrivacy (id,post_id, user_id) VALUES (?,?, ?)",
-					privacyID, postID, user)
-				if err != nil {
-					http.Error(w, fmt.Sprintf("Error inserting post privacy: %v", err), http.StatusInternalServerError)
-					return
-				}
+
+		uploads, ok := attachments.SaveUploads(w, r, post.Image)
+		if !ok {
+			return
+		}
+		committed := false
+		defer func() {
+			if !committed {
+				attachments.Discard(uploads)
 			}
+		}()
+		if len(uploads) > 0 {
+			post.Image = uploads[0].Filename
+		}
+
+		uuidV7, err := uuid.NewV7()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error generating UUID: %v", err), http.StatusInternalServerError)
+			return
+		}
+		postID := uuidV7.String()
+
+		tx, err := db.DB.Begin()
+		if err != nil {
+			logger.LogError("Error starting transaction", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec("INSERT INTO posts (id, title, content, user_id, author, creation_date, status,image, state, publish_at, content_warning, sensitive, comment_policy) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			postID, post.Title, post.Content, userid, author, time.Now(), post.Status, post.Image, state, publishAt, contentWarning, sensitive, commentPolicy)
+		if err != nil {
+			fmt.Println("Error inserting post:", err)
+			http.Error(w, fmt.Sprintf("Error inserting post: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := attachments.Insert(tx, attachments.TargetPost, postID, uploads); err != nil {
+			http.Error(w, fmt.Sprintf("Error inserting attachments: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := addAudience(tx, postID, audience); err != nil {
+			http.Error(w, fmt.Sprintf("Error inserting post privacy: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := audiences.Attach(tx, postID, lists); err != nil {
+			http.Error(w, fmt.Sprintf("Error inserting post audience lists: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := polls.Create(tx, polls.TargetPost, postID, userid, poll); err != nil {
+			http.Error(w, fmt.Sprintf("Error inserting poll: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := verdict.Flag(tx, moderation.TargetPost, postID, ""); err != nil {
+			http.Error(w, fmt.Sprintf("Error flagging post: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			logger.LogError("Error committing transaction", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		committed = true
+
+		message := "Post created successfully"
+		switch state {
+		case StatePublished:
+			runPublishHooks(postID)
+		case StateDraft:
+			message = "Draft saved successfully"
+		case StateScheduled:
+			message = "Post scheduled successfully"
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "Post created successfully"})
+		json.NewEncoder(w).Encode(map[string]string{"message": message, "post_id": postID, "state": state})
 	}
 }
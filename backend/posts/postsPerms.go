@@ -3,16 +3,19 @@ package posts
 import (
 	"fmt"
 
+	"social-net/audiences"
 	"social-net/db"
+	"social-net/moderation"
 )
 
 func CheckUserPostPermission(userID string, postID string) bool {
 	var (
 		postOwnerID string
 		status      string
+		state       string
 	)
 
-	err := db.DB.QueryRow(`SELECT user_id, status FROM posts WHERE id = ?`, postID).Scan(&postOwnerID, &status)
+	err := db.DB.QueryRow(`SELECT user_id, status, state FROM posts WHERE id = ?`, postID).Scan(&postOwnerID, &status, &state)
 	if err != nil {
 		fmt.Println("Error fetching post details 1:", err)
 		return false
@@ -21,6 +24,9 @@ func CheckUserPostPermission(userID string, postID string) bool {
 	if userID == postOwnerID {
 		return true
 	}
+	if state != StatePublished || moderation.IsHidden(moderation.TargetPost, postID) {
+		return false
+	}
 
 	switch status {
 	case "public":
@@ -38,6 +44,14 @@ func CheckUserPostPermission(userID string, postID string) bool {
 			fmt.Println("Error fetching post details 2:", err)
 			return false
 		}
+		if exists {
+			return true
+		}
+		exists, err = audiences.IsMember(userID, postID)
+		if err != nil {
+			fmt.Println("Error fetching post details 2:", err)
+			return false
+		}
 		return exists
 
 	case "private":
@@ -57,3 +71,12 @@ func CheckUserPostPermission(userID string, postID string) bool {
 
 	return false
 }
+
+// IsPostOwner reports whether userID wrote postID.
+func IsPostOwner(userID string, postID string) bool {
+	var ownerID string
+	if err := db.DB.QueryRow("SELECT user_id FROM posts WHERE id = ?", postID).Scan(&ownerID); err != nil {
+		return false
+	}
+	return ownerID == userID
+}
@@ -0,0 +1,95 @@
+package posts
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	FeedChronological = "chronological"
+	FeedFollowing     = "following"
+	FeedRanked        = "ranked"
+)
+
+const (
+	// RankWindow and RankCandidates bound the posts the ranked feed scores:
+	// the newest RankCandidates published within RankWindow of the first page.
+	RankWindow     = 72 * time.Hour
+	RankCandidates = 500
+	// RankHalfLife is how long it takes a post's score to halve with age.
+	RankHalfLife = 12 * time.Hour
+)
+
+// Signals is everything the ranked feed knows about a post when scoring it.
+// Relationship runs from 0 (stranger) to 1 (the viewer's own post or someone
+// they interact with a lot).
+type Signals struct {
+	Age          time.Duration
+	Reactions    int
+	Comments     int
+	Reposts      int
+	Relationship float64
+}
+
+type Scorer func(Signals) float64
+
+var scorer Scorer = DefaultScore
+
+// SetScorer swaps the function used by the ranked feed.
+func SetScorer(s Scorer) {
+	scorer = s
+}
+
+// DefaultScore favours engagement and close relationships and halves every
+// RankHalfLife, so a post from yesterday with a couple dozen reactions still
+// beats a quiet one from the last hour.
+func DefaultScore(s Signals) float64 {
+	engagement := float64(s.Reactions) + 2*float64(s.Comments) + 3*float64(s.Reposts)
+	age := math.Max(s.Age.Hours(), 0)
+	return (1 + math.Log1p(engagement)) * (1 + s.Relationship) * math.Exp2(-age/RankHalfLife.Hours())
+}
+
+// Rank orders posts by score, highest first, with newer ids first between
+// equal scores, and returns the scores in the new order.
+func Rank(posts []GetPost, signals []Signals, score Scorer) []float64 {
+	order := make([]int, len(posts))
+	scores := make([]float64, len(posts))
+	for i := range posts {
+		order[i] = i
+		scores[i] = score(signals[i])
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if scores[i] != scores[j] {
+			return scores[i] > scores[j]
+		}
+		return posts[i].Id > posts[j].Id
+	})
+
+	sortedPosts := make([]GetPost, len(posts))
+	sortedScores := make([]float64, len(posts))
+	for k, i := range order {
+		sortedPosts[k] = posts[i]
+		sortedScores[k] = scores[i]
+	}
+	copy(posts, sortedPosts)
+	return sortedScores
+}
+
+// Relationship turns follow state and recent interactions into a 0..1
+// strength.
+func Relationship(own, follows, followedBack bool, interactions int) float64 {
+	if own {
+		return 1
+	}
+	strength := 0.0
+	if follows {
+		strength += 0.4
+	}
+	if followedBack {
+		strength += 0.2
+	}
+	strength += 0.4 * math.Min(float64(interactions), 10) / 10
+	return strength
+}
@@ -0,0 +1,146 @@
+package posts
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDefaultScore(t *testing.T) {
+	tests := []struct {
+		name          string
+		higher, lower Signals
+	}{
+		{
+			"newer beats older at equal engagement",
+			Signals{Age: time.Hour, Reactions: 5},
+			Signals{Age: 5 * time.Hour, Reactions: 5},
+		},
+		{
+			"engagement beats none at equal age",
+			Signals{Age: 3 * time.Hour, Reactions: 3},
+			Signals{Age: 3 * time.Hour},
+		},
+		{
+			"comments weigh more than reactions",
+			Signals{Age: time.Hour, Comments: 4},
+			Signals{Age: time.Hour, Reactions: 4},
+		},
+		{
+			"reposts weigh more than comments",
+			Signals{Age: time.Hour, Reposts: 4},
+			Signals{Age: time.Hour, Comments: 4},
+		},
+		{
+			"close relationship beats a stranger",
+			Signals{Age: 2 * time.Hour, Relationship: 0.8},
+			Signals{Age: 2 * time.Hour},
+		},
+		{
+			"busy post from yesterday beats a quiet one from now",
+			Signals{Age: 24 * time.Hour, Reactions: 25},
+			Signals{Age: time.Hour},
+		},
+		{
+			"a few reactions don't outlive two days",
+			Signals{Age: 3 * time.Hour},
+			Signals{Age: 48 * time.Hour, Reactions: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			higher, lower := DefaultScore(tt.higher), DefaultScore(tt.lower)
+			if higher <= lower {
+				t.Errorf("DefaultScore(%+v) = %v, want more than DefaultScore(%+v) = %v", tt.higher, higher, tt.lower, lower)
+			}
+		})
+	}
+}
+
+func TestDefaultScoreHalfLife(t *testing.T) {
+	fresh := DefaultScore(Signals{Reactions: 7})
+	aged := DefaultScore(Signals{Age: RankHalfLife, Reactions: 7})
+	if math.Abs(aged-fresh/2) > 1e-9 {
+		t.Errorf("score after one half-life = %v, want %v", aged, fresh/2)
+	}
+	if future := DefaultScore(Signals{Age: -time.Hour}); future != DefaultScore(Signals{}) {
+		t.Errorf("negative age scored %v, want it treated as zero", future)
+	}
+}
+
+func TestRelationship(t *testing.T) {
+	tests := []struct {
+		name               string
+		own, follows, back bool
+		interactions       int
+		want               float64
+	}{
+		{"own post", true, false, false, 0, 1},
+		{"own post ignores the rest", true, true, true, 50, 1},
+		{"stranger", false, false, false, 0, 0},
+		{"follows", false, true, false, 0, 0.4},
+		{"followed back only", false, false, true, 0, 0.2},
+		{"mutual", false, true, true, 0, 0.6},
+		{"some interactions", false, false, false, 5, 0.2},
+		{"interactions cap at ten", false, false, false, 40, 0.4},
+		{"mutual and busy", false, true, true, 10, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Relationship(tt.own, tt.follows, tt.back, tt.interactions)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Relationship() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRank(t *testing.T) {
+	// fixture feed, newest first as the candidate query returns it
+	posts := []GetPost{{Id: "05"}, {Id: "04"}, {Id: "03"}, {Id: "02"}, {Id: "01"}}
+	signals := []Signals{
+		{Age: 30 * time.Minute},
+		{Age: 2 * time.Hour, Reactions: 2, Relationship: 0.4},
+		{Age: 6 * time.Hour},
+		{Age: 20 * time.Hour, Reactions: 40, Comments: 15, Reposts: 5},
+		{Age: 50 * time.Hour, Reactions: 1},
+	}
+
+	scores := Rank(posts, signals, DefaultScore)
+
+	// 02 is older than 05 and 03 but far busier, so it moves ahead of them
+	want := []string{"04", "02", "05", "03", "01"}
+	for i, id := range want {
+		if posts[i].Id != id {
+			t.Fatalf("Rank order = %v, want %v", ids(posts), want)
+		}
+	}
+	for i := 1; i < len(scores); i++ {
+		if scores[i] > scores[i-1] {
+			t.Errorf("scores not descending: %v", scores)
+		}
+	}
+	if scores[1] != DefaultScore(signals[3]) {
+		t.Errorf("scores[1] = %v, want the score of post 02", scores[1])
+	}
+}
+
+func TestRankBreaksTiesByNewestID(t *testing.T) {
+	posts := []GetPost{{Id: "a"}, {Id: "c"}, {Id: "b"}}
+	signals := make([]Signals, len(posts))
+	flat := func(Signals) float64 { return 1 }
+
+	Rank(posts, signals, flat)
+
+	if got := ids(posts); got[0] != "c" || got[1] != "b" || got[2] != "a" {
+		t.Errorf("Rank order = %v, want [c b a]", got)
+	}
+}
+
+func ids(posts []GetPost) []string {
+	out := make([]string, len(posts))
+	for i, post := range posts {
+		out[i] = post.Id
+	}
+	return out
+}
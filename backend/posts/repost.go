@@ -0,0 +1,244 @@
+package posts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"social-net/db"
+	"social-net/filters"
+	logger "social-net/log"
+	"social-net/moderation"
+	"social-net/notification"
+	"social-net/session"
+
+	"github.com/gofrs/uuid"
+)
+
+type RepostRequest struct {
+	PostID       string `json:"post_id"`
+	Content      string `json:"content"`
+	Status       string `json:"status"`
+	AllowedUsers string `json:"allowed_users"`
+}
+
+func RepostCounts(postID string) (int, int, error) {
+	var reposts, quotes int
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN content != '' THEN 1 ELSE 0 END), 0)
+		FROM posts
+		WHERE repost_of = ? AND state = 'published'
+	`, postID).Scan(&reposts, &quotes)
+	return reposts, quotes, err
+}
+
+// checkRepostAudience makes sure a repost never reaches anyone who couldn't
+// see the original. Public posts can be shared anywhere; private and
+// semi-private ones only with a hand-picked audience that can already see
+// them, or unchanged by their own author.
+func checkRepostAudience(userID, originalOwner, originalStatus, status string, audience []string, originalID string) error {
+	if originalStatus == "public" {
+		return nil
+	}
+	if userID == originalOwner && status == originalStatus && status == "private" {
+		return nil
+	}
+	if status != "semi-private" {
+		return fmt.Errorf("This post can only be reposted as semi-private to people who can already see it")
+	}
+	for _, viewerID := range audience {
+		if !CheckUserPostPermission(viewerID, originalID) {
+			return fmt.Errorf("Some of the selected users cannot see the original post")
+		}
+	}
+	return nil
+}
+
+func Repost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokene, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(tokene.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+	author, ok := session.GetUsernameFromUserID(userID)
+	if !ok || author == "" {
+		http.Error(w, "Unauthorized: User not found", http.StatusUnauthorized)
+		return
+	}
+
+	var request RepostRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	request.Content = strings.TrimSpace(request.Content)
+	if request.PostID == "" {
+		http.Error(w, "Missing post_id", http.StatusBadRequest)
+		return
+	}
+	if len(request.Content) > 1000 {
+		http.Error(w, "Content must not exceed 1000 characters", http.StatusBadRequest)
+		return
+	}
+	if !CheckUserPostPermission(userID, request.PostID) {
+		http.Error(w, "Unauthorized: You cannot access this post", http.StatusUnauthorized)
+		return
+	}
+
+	var originalOwner, originalStatus, originalState, originalContent string
+	var originalRepostOf sql.NullString
+	err = db.DB.QueryRow("SELECT user_id, status, state, content, repost_of FROM posts WHERE id = ?", request.PostID).
+		Scan(&originalOwner, &originalStatus, &originalState, &originalContent, &originalRepostOf)
+	if err != nil {
+		logger.LogError("Error fetching post", err)
+		http.Error(w, "Error fetching post", http.StatusInternalServerError)
+		return
+	}
+	if originalState != StatePublished {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	// reposting a plain repost shares the post it points to
+	if originalRepostOf.Valid && originalContent == "" {
+		request.PostID = originalRepostOf.String
+		err = db.DB.QueryRow("SELECT user_id, status FROM posts WHERE id = ?", request.PostID).Scan(&originalOwner, &originalStatus)
+		if err != nil {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
+		if !CheckUserPostPermission(userID, request.PostID) {
+			http.Error(w, "Unauthorized: You cannot access this post", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	status := strings.ToLower(strings.TrimSpace(request.Status))
+	if status == "" {
+		status = originalStatus
+	}
+	if status != "public" && status != "private" && status != "semi-private" {
+		http.Error(w, "Invalid post status", http.StatusBadRequest)
+		return
+	}
+	var audience []string
+	if status == "semi-private" {
+		audience, err = ResolveAudience(request.AllowedUsers)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusBadRequest)
+			return
+		}
+		if len(audience) == 0 {
+			http.Error(w, "Semi-private posts need at least one allowed user", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := checkRepostAudience(userID, originalOwner, originalStatus, status, audience, request.PostID); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if request.Content == "" {
+		var exists bool
+		err = db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE user_id = ? AND repost_of = ? AND content = '')",
+			userID, request.PostID).Scan(&exists)
+		if err != nil {
+			logger.LogError("Error checking repost", err)
+			http.Error(w, "Error checking repost", http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			http.Error(w, "You already reposted this post", http.StatusConflict)
+			return
+		}
+	}
+
+	verdict, ok := filters.Apply(w, "", &request.Content)
+	if !ok {
+		return
+	}
+
+	repostID, err := uuid.NewV7()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error generating UUID: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO posts (id, title, content, user_id, author, creation_date, status, image, state, repost_of) VALUES (?, '', ?, ?, ?, ?, ?, '', ?, ?)",
+		repostID.String(), request.Content, userID, author, time.Now(), status, StatePublished, request.PostID)
+	if err != nil {
+		logger.LogError("Error inserting repost", err)
+		http.Error(w, "Error inserting repost", http.StatusInternalServerError)
+		return
+	}
+	if err := addAudience(tx, repostID.String(), audience); err != nil {
+		logger.LogError("Error inserting post privacy", err)
+		http.Error(w, "Error inserting repost", http.StatusInternalServerError)
+		return
+	}
+	if err := verdict.Flag(tx, moderation.TargetPost, repostID.String(), ""); err != nil {
+		logger.LogError("Error flagging repost", err)
+		http.Error(w, "Error inserting repost", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	runPublishHooks(repostID.String())
+
+	if originalOwner != userID {
+		if owner, ok := session.GetUsernameFromUserID(originalOwner); ok {
+			action := " reposted your post"
+			if request.Content != "" {
+				action = " quoted your post"
+			}
+			notification.CreateNotificationAbout(owner, author, notification.TypeRepost, author+action, notification.EntityPost, repostID.String())
+		}
+	}
+
+	reposts, quotes, err := RepostCounts(request.PostID)
+	if err != nil {
+		logger.LogError("Error counting reposts", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "Post reposted successfully",
+		"post_id":      repostID.String(),
+		"repost_of":    request.PostID,
+		"repost_count": reposts,
+		"quote_count":  quotes,
+	})
+}
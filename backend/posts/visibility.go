@@ -0,0 +1,221 @@
+package posts
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"social-net/audiences"
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/mentions"
+	"social-net/session"
+)
+
+type VisibilityRequest struct {
+	PostID        string `json:"post_id"`
+	Status        string `json:"status"`
+	AllowedUsers  string `json:"allowed_users"`
+	AudienceLists string `json:"audience_lists"`
+	// RemoveComments deletes the comments of people who can no longer see
+	// the post; by default they stay for those who still can.
+	RemoveComments bool `json:"remove_comments"`
+}
+
+// SetVisibility changes the status of a post and replaces its audience.
+// Access is always checked at read time, so feeds, comments, bookmarks and
+// notifications follow the new setting without further work here.
+func SetVisibility(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokene, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(tokene.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var request VisibilityRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.PostID == "" {
+		http.Error(w, "Missing post_id", http.StatusBadRequest)
+		return
+	}
+	request.Status = strings.ToLower(strings.TrimSpace(request.Status))
+	if request.Status != "public" && request.Status != "private" && request.Status != "semi-private" {
+		http.Error(w, "Invalid post status", http.StatusBadRequest)
+		return
+	}
+	if !checkPostOwner(w, userID, request.PostID) {
+		return
+	}
+
+	var audience, lists []string
+	if request.Status == "semi-private" {
+		audience, err = ResolveAudience(request.AllowedUsers)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusBadRequest)
+			return
+		}
+		lists, err = audiences.Owned(userID, request.AudienceLists)
+		if err != nil {
+			http.Error(w, "Audience list not found", http.StatusBadRequest)
+			return
+		}
+		if len(audience) == 0 && len(lists) == 0 {
+			http.Error(w, "Semi-private posts need at least one allowed user or audience list", http.StatusBadRequest)
+			return
+		}
+	} else if strings.TrimSpace(request.AllowedUsers) != "" || strings.TrimSpace(request.AudienceLists) != "" {
+		http.Error(w, "Only semi-private posts have an audience", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		logger.LogError("Error starting transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE posts SET status = ? WHERE id = ?", request.Status, request.PostID)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM postsPrivacy WHERE post_id = ?", request.PostID)
+	}
+	if err == nil {
+		err = audiences.Detach(tx, request.PostID)
+	}
+	if err == nil {
+		err = addAudience(tx, request.PostID, audience)
+	}
+	if err == nil {
+		err = audiences.Attach(tx, request.PostID, lists)
+	}
+	if err != nil {
+		logger.LogError("Error updating post visibility", err)
+		http.Error(w, "Error updating post visibility", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.LogError("Error committing transaction", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	mentions.RecordPost(request.PostID)
+
+	lostAccess, err := commentersWithoutAccess(request.PostID)
+	if err != nil {
+		logger.LogError("Error checking commenters", err)
+	}
+	removed := 0
+	if request.RemoveComments && len(lostAccess) > 0 {
+		removed, err = removeComments(request.PostID, lostAccess)
+		if err != nil {
+			logger.LogError("Error removing comments", err)
+			http.Error(w, "Visibility updated, but removing comments failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":                   "Post visibility updated successfully",
+		"status":                    request.Status,
+		"commenters_without_access": len(lostAccess),
+		"removed_comments":          removed,
+	})
+}
+
+// commentersWithoutAccess returns the usernames of people who commented on
+// a post but are no longer allowed to see it.
+func commentersWithoutAccess(postID string) ([]string, error) {
+	rows, err := db.DB.Query(`
+		SELECT DISTINCT u.id, u.username
+		FROM comments c JOIN users u ON u.username = c.author
+		WHERE c.target_type = 'post' AND c.target_id = ?
+	`, postID)
+	if err != nil {
+		return nil, err
+	}
+	type commenter struct{ id, username string }
+	var commenters []commenter
+	for rows.Next() {
+		var c commenter
+		if err := rows.Scan(&c.id, &c.username); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		commenters = append(commenters, c)
+	}
+	rows.Close()
+
+	var lost []string
+	for _, c := range commenters {
+		if !CheckUserPostPermission(c.id, postID) {
+			lost = append(lost, c.username)
+		}
+	}
+	return lost, nil
+}
+
+func removeComments(postID string, authors []string) (int, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	removed := 0
+	for _, author := range authors {
+		// replies from people who keep access move up to the nearest
+		// remaining ancestor instead of being cut off from the thread
+		for {
+			result, err := tx.Exec(`
+				UPDATE comments SET parent_id = (SELECT p.parent_id FROM comments p WHERE p.id = comments.parent_id)
+				WHERE parent_id IN (SELECT id FROM comments WHERE target_type = 'post' AND target_id = ? AND author = ?)
+			`, postID, author)
+			if err != nil {
+				return 0, err
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				break
+			}
+		}
+		for _, query := range []string{
+			"DELETE FROM reactions WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE target_type = 'post' AND target_id = ? AND author = ?)",
+			"DELETE FROM mentions WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE target_type = 'post' AND target_id = ? AND author = ?)",
+		} {
+			if _, err := tx.Exec(query, postID, author); err != nil {
+				return 0, err
+			}
+		}
+		result, err := tx.Exec("DELETE FROM comments WHERE target_type = 'post' AND target_id = ? AND author = ?", postID, author)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		removed += int(n)
+	}
+	return removed, tx.Commit()
+}
@@ -0,0 +1,228 @@
+package previews
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultTimeout  = 5 * time.Second
+	DefaultMaxBytes = 512 * 1024
+	MaxRedirects    = 3
+)
+
+var ErrBlockedAddress = errors.New("address is not allowed")
+
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	SiteName    string `json:"site_name"`
+}
+
+// Fetcher downloads a page and reads its OpenGraph / Twitter card tags.
+// AllowPrivate exists for tests against a local httptest server; the default
+// fetcher refuses to connect to loopback, private and link-local addresses.
+type Fetcher struct {
+	Timeout      time.Duration
+	MaxBytes     int64
+	AllowPrivate bool
+
+	client *http.Client
+}
+
+func NewFetcher(allowPrivate bool) *Fetcher {
+	f := &Fetcher{Timeout: DefaultTimeout, MaxBytes: DefaultMaxBytes, AllowPrivate: allowPrivate}
+	dialer := &net.Dialer{Timeout: f.Timeout, Control: f.control}
+	f.client = &http.Client{
+		Timeout: f.Timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   f.Timeout,
+			ResponseHeaderTimeout: f.Timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > MaxRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("unsupported redirect scheme")
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// control runs after DNS resolution, right before connecting, so a hostname
+// that resolves (or rebinds) to an internal address is still refused.
+func (f *Fetcher) control(network, address string, _ syscall.RawConn) error {
+	if f.AllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || IsBlockedIP(ip) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+var blockedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"100.64.0.0/10",
+		"192.0.0.0/24",
+		"198.18.0.0/15",
+		"240.0.0.0/4",
+		"64:ff9b::/96",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+func IsBlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (Preview, error) {
+	preview := Preview{URL: rawURL}
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return preview, errors.New("invalid url")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return preview, err
+	}
+	req.Header.Set("User-Agent", "social-net-preview/1.0")
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return preview, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return preview, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return preview, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxBytes))
+	if err != nil {
+		return preview, err
+	}
+	preview = Parse(resp.Request.URL, string(body))
+	preview.URL = rawURL
+	if preview.Title == "" && preview.Description == "" {
+		return preview, errors.New("no preview metadata")
+	}
+	return preview, nil
+}
+
+var (
+	metaPattern  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrPattern  = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// Parse reads OpenGraph tags first, then Twitter cards, then the plain
+// <title> and description. Relative image URLs are resolved against base.
+func Parse(base *url.URL, document string) Preview {
+	tags := map[string]string{}
+	for _, meta := range metaPattern.FindAllString(document, -1) {
+		attrs := map[string]string{}
+		for _, attr := range attrPattern.FindAllStringSubmatch(meta, -1) {
+			attrs[strings.ToLower(attr[1])] = attr[2] + attr[3] + attr[4]
+		}
+		key := strings.ToLower(attrs["property"])
+		if key == "" {
+			key = strings.ToLower(attrs["name"])
+		}
+		if key != "" && tags[key] == "" {
+			tags[key] = strings.TrimSpace(html.UnescapeString(attrs["content"]))
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if tags[key] != "" {
+				return tags[key]
+			}
+		}
+		return ""
+	}
+
+	preview := Preview{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		Image:       first("og:image", "og:image:url", "twitter:image", "twitter:image:src"),
+		SiteName:    first("og:site_name"),
+	}
+	if preview.Title == "" {
+		if match := titlePattern.FindStringSubmatch(document); match != nil {
+			preview.Title = strings.TrimSpace(html.UnescapeString(match[1]))
+		}
+	}
+	if preview.Image != "" && base != nil {
+		if ref, err := url.Parse(preview.Image); err == nil {
+			resolved := base.ResolveReference(ref)
+			if resolved.Scheme == "http" || resolved.Scheme == "https" {
+				preview.Image = resolved.String()
+			} else {
+				preview.Image = ""
+			}
+		}
+	}
+	if preview.SiteName == "" && base != nil {
+		preview.SiteName = base.Hostname()
+	}
+	preview.Title = truncate(preview.Title, 200)
+	preview.Description = truncate(preview.Description, 500)
+	return preview
+}
+
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max])
+}
@@ -0,0 +1,264 @@
+package previews
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func page(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, body)
+	}
+}
+
+func TestFetchOpenGraph(t *testing.T) {
+	server := serve(t, page(`<html><head>
+		<title>Plain title</title>
+		<meta property="og:title" content="OG &amp; title">
+		<meta property="og:description" content='OG description'>
+		<meta property="og:image" content="/img/card.png">
+		<meta property="og:site_name" content="Example">
+		<meta name="twitter:title" content="Twitter title">
+	</head></html>`))
+
+	preview, err := NewFetcher(true).Fetch(context.Background(), server.URL+"/articles/1")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	want := Preview{
+		URL:         server.URL + "/articles/1",
+		Title:       "OG & title",
+		Description: "OG description",
+		Image:       server.URL + "/img/card.png",
+		SiteName:    "Example",
+	}
+	if preview != want {
+		t.Errorf("Fetch() = %+v, want %+v", preview, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://news.example/a/b/page.html")
+	tests := []struct {
+		name     string
+		document string
+		want     Preview
+	}{
+		{
+			"twitter card fallback",
+			`<meta name="twitter:title" content="TW title"><meta name="twitter:description" content="TW desc"><meta name="twitter:image" content="https://cdn.example/x.jpg">`,
+			Preview{Title: "TW title", Description: "TW desc", Image: "https://cdn.example/x.jpg", SiteName: "news.example"},
+		},
+		{
+			"og wins over twitter",
+			`<meta name="twitter:title" content="TW"><meta property="og:title" content="OG">`,
+			Preview{Title: "OG", SiteName: "news.example"},
+		},
+		{
+			"title tag and description",
+			`<title> Plain </title><meta name="description" content="Desc">`,
+			Preview{Title: "Plain", Description: "Desc", SiteName: "news.example"},
+		},
+		{
+			"relative image",
+			`<meta property="og:title" content="T"><meta property="og:image" content="../img/c.png">`,
+			Preview{Title: "T", Image: "https://news.example/a/img/c.png", SiteName: "news.example"},
+		},
+		{
+			"scheme-relative image",
+			`<meta property="og:title" content="T"><meta property="og:image" content="//cdn.example/c.png">`,
+			Preview{Title: "T", Image: "https://cdn.example/c.png", SiteName: "news.example"},
+		},
+		{
+			"javascript image dropped",
+			`<meta property="og:title" content="T"><meta property="og:image" content="javascript:alert(1)">`,
+			Preview{Title: "T", SiteName: "news.example"},
+		},
+		{
+			"attribute order and unquoted values",
+			`<META content=Reversed property=og:title>`,
+			Preview{Title: "Reversed", SiteName: "news.example"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(base, tt.document); got != tt.want {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchStopsAtMaxBytes(t *testing.T) {
+	server := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<meta property="og:title" content="Early">`)
+		fmt.Fprint(w, strings.Repeat(" ", 4096))
+		fmt.Fprint(w, `<meta property="og:description" content="Late">`)
+	})
+
+	f := NewFetcher(true)
+	f.MaxBytes = 1024
+	preview, err := f.Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if preview.Title != "Early" || preview.Description != "" {
+		t.Errorf("Fetch() = %+v, want only the title before the cutoff", preview)
+	}
+}
+
+func TestFetchDoesNotReadEndlessBodies(t *testing.T) {
+	server := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>Endless</title>`)
+		chunk := []byte(strings.Repeat("x", 1024))
+		for r.Context().Err() == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	})
+
+	f := NewFetcher(true)
+	f.MaxBytes = 64 * 1024
+	preview, err := f.Fetch(context.Background(), server.URL)
+	if err != nil || preview.Title != "Endless" {
+		t.Errorf("Fetch() = %+v, %v", preview, err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	defer close(release)
+
+	f := NewFetcher(true)
+	f.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	_, err := f.Fetch(context.Background(), server.URL)
+	if err == nil {
+		t.Fatal("Fetch() succeeded against a server that never answers")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Fetch() took %v, want it to give up after the timeout", elapsed)
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	var server *httptest.Server
+	server = serve(t, func(w http.ResponseWriter, r *http.Request) {
+		var hops int
+		if _, err := fmt.Sscanf(r.URL.Path, "/hop/%d", &hops); err == nil && hops > 0 {
+			http.Redirect(w, r, fmt.Sprintf("%s/hop/%d", server.URL, hops-1), http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/ftp" {
+			http.Redirect(w, r, "ftp://files.example/x", http.StatusFound)
+			return
+		}
+		page(`<meta property="og:title" content="Landed">`)(w, r)
+	})
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"/hop/0", true},
+		{fmt.Sprintf("/hop/%d", MaxRedirects), true},
+		{fmt.Sprintf("/hop/%d", MaxRedirects+1), false},
+		{"/ftp", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			preview, err := NewFetcher(true).Fetch(context.Background(), server.URL+tt.path)
+			if tt.ok && (err != nil || preview.Title != "Landed") {
+				t.Errorf("Fetch(%s) = %+v, %v, want the landing page", tt.path, preview, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("Fetch(%s) followed the redirect, want an error", tt.path)
+			}
+		})
+	}
+}
+
+func TestFetchRejectsNonHTML(t *testing.T) {
+	server := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("<title>not html</title>"))
+	})
+	if _, err := NewFetcher(true).Fetch(context.Background(), server.URL); err == nil {
+		t.Error("Fetch() accepted an image/png response")
+	}
+}
+
+func TestFetchRefusesPrivateAddresses(t *testing.T) {
+	var hits int
+	server := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		page(`<meta property="og:title" content="Internal">`)(w, r)
+	})
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	for _, target := range []string{
+		server.URL,
+		// a name that resolves to loopback, as a rebinding domain would
+		"http://localhost:" + port,
+	} {
+		_, err := NewFetcher(false).Fetch(context.Background(), target)
+		if !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("Fetch(%s) error = %v, want ErrBlockedAddress", target, err)
+		}
+	}
+	if hits != 0 {
+		t.Errorf("server was reached %d times", hits)
+	}
+}
+
+func TestIsBlockedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"64:ff9b::a00:1", true},
+		{"93.184.216.34", false},
+		{"2606:4700::1111", false},
+	}
+	for _, tt := range tests {
+		if got := IsBlockedIP(net.ParseIP(tt.ip)); got != tt.blocked {
+			t.Errorf("IsBlockedIP(%s) = %v, want %v", tt.ip, got, tt.blocked)
+		}
+	}
+}
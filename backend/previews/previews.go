@@ -0,0 +1,116 @@
+package previews
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"social-net/db"
+	logger "social-net/log"
+)
+
+const (
+	MaxLinksPerText = 3
+
+	okTTL     = 24 * time.Hour
+	failedTTL = time.Hour
+)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+
+var DefaultFetcher = NewFetcher(false)
+
+// ExtractURLs returns up to MaxLinksPerText distinct links from text, without
+// trailing punctuation picked up from the sentence around them.
+func ExtractURLs(text string) []string {
+	seen := map[string]bool{}
+	var urls []string
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		match = strings.TrimRight(match, ".,;:!?)]}")
+		if seen[match] {
+			continue
+		}
+		seen[match] = true
+		urls = append(urls, match)
+		if len(urls) == MaxLinksPerText {
+			break
+		}
+	}
+	return urls
+}
+
+// Warm fetches previews for the links in text that aren't cached yet. It is
+// meant to run in its own goroutine after content is saved.
+func Warm(text string) {
+	for _, link := range ExtractURLs(text) {
+		if cached(link) {
+			continue
+		}
+		preview, err := DefaultFetcher.Fetch(context.Background(), link)
+		status := "ok"
+		if err != nil {
+			status = "failed"
+		}
+		_, err = db.DB.Exec(`
+			INSERT INTO link_previews (url, title, description, image, site_name, status, fetched_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (url) DO UPDATE SET
+				title = excluded.title, description = excluded.description, image = excluded.image,
+				site_name = excluded.site_name, status = excluded.status, fetched_at = excluded.fetched_at
+		`, link, preview.Title, preview.Description, preview.Image, preview.SiteName, status, time.Now().UTC())
+		if err != nil {
+			logger.LogError("Error caching link preview", err)
+		}
+	}
+}
+
+func cached(link string) bool {
+	var status string
+	var fetchedAt time.Time
+	err := db.DB.QueryRow("SELECT status, fetched_at FROM link_previews WHERE url = ?", link).Scan(&status, &fetchedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.LogError("Error reading link preview", err)
+		}
+		return false
+	}
+	ttl := okTTL
+	if status != "ok" {
+		ttl = failedTTL
+	}
+	return time.Since(fetchedAt) < ttl
+}
+
+// ForText returns the cached previews for the links in text. It never fetches,
+// so rendering a feed doesn't wait on other sites.
+func ForText(text string) []Preview {
+	list := []Preview{}
+	for _, link := range ExtractURLs(text) {
+		var preview Preview
+		err := db.DB.QueryRow(`
+			SELECT url, title, description, image, site_name
+			FROM link_previews
+			WHERE url = ? AND status = 'ok'
+		`, link).Scan(&preview.URL, &preview.Title, &preview.Description, &preview.Image, &preview.SiteName)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				logger.LogError("Error reading link preview", err)
+			}
+			continue
+		}
+		list = append(list, preview)
+	}
+	return list
+}
+
+// WarmPost is run when a post is published.
+func WarmPost(postID string) {
+	var content string
+	if err := db.DB.QueryRow("SELECT content FROM posts WHERE id = ?", postID).Scan(&content); err != nil {
+		logger.LogError("Error fetching post for link previews", err)
+		return
+	}
+	go Warm(content)
+}
@@ -6,10 +6,23 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
+	"social-net/attachments"
+	"social-net/audiences"
 	"social-net/db"
 	logger "social-net/log"
+	"social-net/mentions"
+	"social-net/moderation"
+	"social-net/pagination"
+	"social-net/polls"
+	postspkg "social-net/posts"
+	"social-net/previews"
+	"social-net/reactions"
+	"social-net/richtext"
 	"social-net/session"
+	"social-net/views"
+	"social-net/warnings"
 )
 
 type UserInfo struct {
@@ -31,16 +44,37 @@ type UserInfo struct {
 }
 
 type GetPost struct {
-	Id            string `json:"id"`
-	User_id       string `json:"user_id"`
-	Author        string `json:"author"`
-	Content       string `json:"content"`
-	Title         string `json:"title"`
-	Creation_date string `json:"creation_date"`
-	Status        string `json:"status"`
-	Avatar        string `json:"avatar"`
-	Image         string `json:"image"`
-	CommentsCount int    `json:"comments_count"`
+	Id             string                   `json:"id"`
+	User_id        string                   `json:"user_id"`
+	Author         string                   `json:"author"`
+	Content        string                   `json:"content"`
+	ContentSpans   []mentions.Span          `json:"content_spans"`
+	ContentHTML    string                   `json:"content_html"`
+	LinkPreviews   []previews.Preview       `json:"link_previews"`
+	Title          string                   `json:"title"`
+	Creation_date  string                   `json:"creation_date"`
+	Status         string                   `json:"status"`
+	Avatar         string                   `json:"avatar"`
+	Image          string                   `json:"image"`
+	CommentsCount  int                      `json:"comments_count"`
+	Edited         bool                     `json:"edited"`
+	Edited_at      string                   `json:"edited_at"`
+	Reactions      map[string]int           `json:"reactions"`
+	MyReaction     string                   `json:"my_reaction"`
+	Attachments    []attachments.Attachment `json:"attachments"`
+	RepostOf       string                   `json:"repost_of"`
+	RepostedBy     string                   `json:"reposted_by"`
+	Original       *postspkg.GetPost        `json:"original"`
+	RepostCount    int                      `json:"repost_count"`
+	QuoteCount     int                      `json:"quote_count"`
+	Poll           *polls.Poll              `json:"poll"`
+	ContentWarning string                   `json:"content_warning"`
+	Sensitive      bool                     `json:"sensitive"`
+	Collapsed      bool                     `json:"collapsed"`
+	MediaHidden    bool                     `json:"media_hidden"`
+	CommentPolicy  string                   `json:"comment_policy"`
+	CommentsLocked bool                     `json:"comments_locked"`
+	CanComment     bool                     `json:"can_comment"`
 }
 
 type Comments struct {
@@ -91,6 +125,10 @@ func GetUserInfo(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error fetching user ID", http.StatusInternalServerError)
 		return
 	}
+	if userID != user_id && moderation.IsHidden(moderation.TargetProfile, userID) {
+		http.Error(w, "This profile is unavailable", http.StatusNotFound)
+		return
+	}
 
 	var userInfo UserInfo
 	err = db.DB.QueryRow("SELECT username, email, first_name, last_name, bio, date_of_birth, privacy, avatar, nickname FROM users WHERE id = ?", userID).Scan(
@@ -149,7 +187,7 @@ func GetUserInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var postCount int
-	err = db.DB.QueryRow("SELECT COUNT(*) FROM posts WHERE user_id = ?", userID).Scan(&postCount)
+	err = db.DB.QueryRow("SELECT COUNT(*) FROM posts WHERE user_id = ? AND state = 'published'", userID).Scan(&postCount)
 	if err != nil {
 		logger.LogError("Error counting posts", err)
 		http.Error(w, "Error getting post count", http.StatusInternalServerError)
@@ -219,7 +257,7 @@ func UpdatePrivacy(w http.ResponseWriter, r *http.Request) {
 
 	if request.Privacy == "public" {
 
-		_, err = db.DB.Exec("UPDATE followers SET status = 'accepted' WHERE followed_id = (SELECT id FROM users WHERE username = ?) AND status = 'pending'", username)
+		_, err = db.DB.Exec("UPDATE followers SET status = 'accepted', accepted_at = ? WHERE followed_id = (SELECT id FROM users WHERE username = ?) AND status = 'pending'", time.Now(), username)
 		if err != nil {
 			logger.LogError("Failed to accept pending follow requests", err)
 			http.Error(w, "Failed to accept pending follow requests", http.StatusInternalServerError)
@@ -403,54 +441,140 @@ func GetOwnPosts(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error finding user", http.StatusInternalServerError)
 		return
 	}
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, afterArgs := page.Where("p.creation_date", "p.id")
+
 	query := `
-		SELECT DISTINCT p.id, p.user_id, p.author, p.content, p.title, p.creation_date, p.status, u.avatar, p.image
+		SELECT DISTINCT p.id, p.user_id, p.author, p.content, p.title, p.creation_date, p.status, u.avatar, p.image, p.edited_at, p.repost_of, p.content_warning, p.sensitive, CAST(p.creation_date AS TEXT)
 		FROM posts p
 		LEFT JOIN postsPrivacy pp ON p.id = pp.post_id
 		LEFT JOIN users u ON p.user_id = u.id
-		WHERE p.user_id = ?
+		WHERE p.user_id = ? AND p.state = 'published'
   		AND (
     	p.status = 'public'
     	OR (p.status = 'private' AND EXISTS (
         SELECT 1 FROM followers WHERE follower_id = ? AND followed_id = ? AND status = 'accepted'
     	))
-    	OR (p.status = 'semi-private' AND pp.user_id = ?)
+    	OR (p.status = 'semi-private' AND (pp.user_id = ? OR ` + audiences.MemberCondition + `))
     	OR (? = p.user_id)
   )
-ORDER BY p.creation_date DESC
+		AND (? = p.user_id OR ` + moderation.Visible(moderation.TargetPost, "p.id") + `)
+		AND ` + after + `
+ORDER BY p.creation_date DESC, p.id DESC
+		LIMIT ?
 	`
-	rows, err := db.DB.Query(query, userID, CurrentUserid, userID, CurrentUserid, CurrentUserid)
+	args := append([]interface{}{userID, CurrentUserid, userID, CurrentUserid, CurrentUserid, CurrentUserid, CurrentUserid}, afterArgs...)
+	rows, err := db.DB.Query(query, append(args, page.Fetch())...)
 	if err != nil {
 		http.Error(w, "Error querying posts", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var posts []GetPost
+	preference := warnings.Preference(CurrentUserid)
+	posts := []GetPost{}
+	var scanned int
+	var last pagination.Cursor
 	for rows.Next() {
+		scanned++
+		if scanned > page.Limit {
+			break
+		}
 		var post GetPost
-		err := rows.Scan(&post.Id, &post.User_id, &post.Author, &post.Content, &post.Title, &post.Creation_date, &post.Status, &post.Avatar, &post.Image)
+		var editedAt, repostOf sql.NullString
+		err := rows.Scan(&post.Id, &post.User_id, &post.Author, &post.Content, &post.Title, &post.Creation_date, &post.Status, &post.Avatar, &post.Image, &editedAt, &repostOf, &post.ContentWarning, &post.Sensitive, &last.Time)
 		if err != nil {
 			http.Error(w, "Error scanning posts", http.StatusInternalServerError)
 			return
 		}
+		last.ID = post.Id
+		if repostOf.Valid {
+			var visible bool
+			post.RepostOf = repostOf.String
+			post.Original, visible = postspkg.LoadPost(CurrentUserid, post.RepostOf)
+			if !visible && post.Content == "" {
+				continue
+			}
+			if post.Content == "" {
+				post.RepostedBy = post.Author
+			}
+		}
+		post.ContentSpans = mentions.Spans(post.Content)
+		post.ContentHTML = richtext.Render(post.Content)
+		post.LinkPreviews = previews.ForText(post.Content)
+		post.Edited = editedAt.Valid
+		post.Edited_at = editedAt.String
+		post.Collapsed, post.MediaHidden = warnings.Apply(preference, post.ContentWarning, post.Sensitive)
+		if post.MediaHidden {
+			post.Image = ""
+		}
 		posts = append(posts, post)
 	}
 
 	for i, post := range posts {
 
 		var commentsCount int
-		err := db.DB.QueryRow("SELECT COUNT(*) FROM comments WHERE post_id = ?", post.Id).Scan(&commentsCount)
+		err := db.DB.QueryRow("SELECT COUNT(*) FROM comments WHERE target_type = 'post' AND target_id = ?", post.Id).Scan(&commentsCount)
 		if err != nil {
 			fmt.Println("Error getting comments count:", err)
 			http.Error(w, "Error getting comments count", http.StatusInternalServerError)
 			return
 		}
 		posts[i].CommentsCount = commentsCount
+		posts[i].CommentPolicy, posts[i].CommentsLocked, err = postspkg.CommentSettings(post.Id)
+		if err != nil {
+			fmt.Println("Error getting comment settings:", err)
+		}
+		posts[i].CanComment, _ = postspkg.CanComment(CurrentUserid, post.Id)
+
+		posts[i].Reactions, posts[i].MyReaction, err = reactions.Summary(CurrentUserid, reactions.TargetPost, post.Id)
+		if err != nil {
+			fmt.Println("Error counting reactions:", err)
+			http.Error(w, "Error counting reactions", http.StatusInternalServerError)
+			return
+		}
+
+		posts[i].Attachments = []attachments.Attachment{}
+		if !post.MediaHidden {
+			posts[i].Attachments, err = attachments.List(attachments.TargetPost, post.Id)
+		}
+		if err != nil {
+			fmt.Println("Error fetching attachments:", err)
+			http.Error(w, "Error fetching attachments", http.StatusInternalServerError)
+			return
+		}
+
+		posts[i].RepostCount, posts[i].QuoteCount, err = postspkg.RepostCounts(post.Id)
+		if err != nil {
+			fmt.Println("Error counting reposts:", err)
+			http.Error(w, "Error counting reposts", http.StatusInternalServerError)
+			return
+		}
+
+		posts[i].Poll, err = polls.ForTarget(CurrentUserid, polls.TargetPost, post.Id)
+		if err != nil {
+			fmt.Println("Error fetching poll:", err)
+			http.Error(w, "Error fetching poll", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	viewed := make(map[string]string, len(posts))
+	for _, post := range posts {
+		postID, authorID := postspkg.ViewOf(post.Id, post.User_id, post.Content, post.Original)
+		viewed[postID] = authorID
 	}
+	views.Record(CurrentUserid, viewed)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(posts)
+	json.NewEncoder(w).Encode(pagination.Response{
+		Items:      posts,
+		NextCursor: page.Next(scanned, last),
+	})
 }
 
 func GetFollowersAndFollowing(w http.ResponseWriter, r *http.Request) {
@@ -721,7 +845,7 @@ func AcceptInvitation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = db.DB.Exec("UPDATE Followers SET status = 'accepted' WHERE follower_id = ? AND followed_id = ?", follower_id, userID)
+	_, err = db.DB.Exec("UPDATE Followers SET status = 'accepted', accepted_at = ? WHERE follower_id = ? AND followed_id = ?", time.Now(), follower_id, userID)
 	if err != nil {
 		fmt.Println("Error updating invitation status:", err)
 		http.Error(w, "Failed to update invitation status", http.StatusInternalServerError)
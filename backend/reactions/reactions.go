@@ -0,0 +1,329 @@
+package reactions
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/notification"
+	"social-net/session"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	TargetPost      = "post"
+	TargetComment   = "comment"
+	TargetGroupPost = "group_post"
+)
+
+var Allowed = map[string]bool{
+	"👍":  true,
+	"❤️": true,
+	"😂":  true,
+	"😮":  true,
+	"😢":  true,
+	"😡":  true,
+}
+
+var targetLabels = map[string]string{
+	TargetPost:      "post",
+	TargetComment:   "comment",
+	TargetGroupPost: "group post",
+}
+
+var authorQueries = map[string]string{
+	TargetPost:      "SELECT u.username FROM posts p JOIN users u ON p.user_id = u.id WHERE p.id = ?",
+	TargetComment:   "SELECT author FROM comments WHERE id = ?",
+	TargetGroupPost: "SELECT u.username FROM group_posts p JOIN users u ON p.user_id = u.id WHERE p.id = ?",
+}
+
+var accessChecks = map[string]func(userID string, targetID string) bool{}
+
+// SetAccessCheck registers the visibility rule for a target type. Reactions on
+// a type without a registered check are refused.
+func SetAccessCheck(targetType string, check func(userID string, targetID string) bool) {
+	accessChecks[targetType] = check
+}
+
+type ReactionRequest struct {
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+	Reaction   string `json:"reaction"`
+}
+
+type Reaction struct {
+	Username  string    `json:"username"`
+	Avatar    string    `json:"avatar"`
+	Reaction  string    `json:"reaction"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func Summary(userID, targetType, targetID string) (map[string]int, string, error) {
+	counts := map[string]int{}
+	rows, err := db.DB.Query(`
+		SELECT reaction, COUNT(*)
+		FROM reactions
+		WHERE target_type = ? AND target_id = ?
+		GROUP BY reaction
+	`, targetType, targetID)
+	if err != nil {
+		return counts, "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reaction string
+		var count int
+		if err := rows.Scan(&reaction, &count); err != nil {
+			return counts, "", err
+		}
+		counts[reaction] = count
+	}
+
+	var mine string
+	err = db.DB.QueryRow("SELECT reaction FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, targetType, targetID).Scan(&mine)
+	if err != nil && err != sql.ErrNoRows {
+		return counts, "", err
+	}
+	return counts, mine, nil
+}
+
+func canReact(w http.ResponseWriter, userID, targetType, targetID string) bool {
+	check, ok := accessChecks[targetType]
+	if !ok {
+		http.Error(w, "Invalid target type", http.StatusBadRequest)
+		return false
+	}
+	if targetID == "" {
+		http.Error(w, "Missing target_id", http.StatusBadRequest)
+		return false
+	}
+	if !check(userID, targetID) {
+		http.Error(w, "Unauthorized: You cannot access this content", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func AddReaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var request ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !Allowed[request.Reaction] {
+		http.Error(w, "Invalid reaction", http.StatusBadRequest)
+		return
+	}
+	if !canReact(w, userID, request.TargetType, request.TargetID) {
+		return
+	}
+
+	var existing string
+	err = db.DB.QueryRow("SELECT reaction FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, request.TargetType, request.TargetID).Scan(&existing)
+	if err != nil && err != sql.ErrNoRows {
+		logger.LogError("Error fetching reaction", err)
+		http.Error(w, "Error fetching reaction", http.StatusInternalServerError)
+		return
+	}
+	isNew := err == sql.ErrNoRows
+
+	reactionID, err := uuid.NewV7()
+	if err != nil {
+		http.Error(w, "Failed to generate reaction ID", http.StatusInternalServerError)
+		return
+	}
+	_, err = db.DB.Exec(`
+		INSERT INTO reactions (id, user_id, target_type, target_id, reaction, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, target_type, target_id)
+		DO UPDATE SET reaction = excluded.reaction, created_at = excluded.created_at
+	`, reactionID.String(), userID, request.TargetType, request.TargetID, request.Reaction, time.Now())
+	if err != nil {
+		logger.LogError("Error saving reaction", err)
+		http.Error(w, "Error saving reaction", http.StatusInternalServerError)
+		return
+	}
+
+	if isNew {
+		notifyAuthor(userID, request)
+	}
+
+	counts, mine, err := Summary(userID, request.TargetType, request.TargetID)
+	if err != nil {
+		logger.LogError("Error counting reactions", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reactions":   counts,
+		"my_reaction": mine,
+	})
+}
+
+func RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var request ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, ok := accessChecks[request.TargetType]; !ok {
+		http.Error(w, "Invalid target type", http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.DB.Exec("DELETE FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, request.TargetType, request.TargetID)
+	if err != nil {
+		logger.LogError("Error removing reaction", err)
+		http.Error(w, "Error removing reaction", http.StatusInternalServerError)
+		return
+	}
+
+	counts, _, err := Summary(userID, request.TargetType, request.TargetID)
+	if err != nil {
+		logger.LogError("Error counting reactions", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reactions":   counts,
+		"my_reaction": "",
+	})
+}
+
+func GetReactions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	targetType := r.URL.Query().Get("target_type")
+	targetID := r.URL.Query().Get("target_id")
+	if !canReact(w, userID, targetType, targetID) {
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT u.username, u.avatar, r.reaction, r.created_at
+		FROM reactions r
+		JOIN users u ON r.user_id = u.id
+		WHERE r.target_type = ? AND r.target_id = ?
+		ORDER BY r.created_at DESC
+	`, targetType, targetID)
+	if err != nil {
+		logger.LogError("Error fetching reactions", err)
+		http.Error(w, "Error fetching reactions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list := []Reaction{}
+	for rows.Next() {
+		var reaction Reaction
+		var avatar sql.NullString
+		if err := rows.Scan(&reaction.Username, &avatar, &reaction.Reaction, &reaction.CreatedAt); err != nil {
+			logger.LogError("Error scanning reaction", err)
+			http.Error(w, "Error scanning reaction", http.StatusInternalServerError)
+			return
+		}
+		reaction.Avatar = avatar.String
+		list = append(list, reaction)
+	}
+
+	counts, mine, err := Summary(userID, targetType, targetID)
+	if err != nil {
+		logger.LogError("Error counting reactions", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reactions":   counts,
+		"my_reaction": mine,
+		"users":       list,
+	})
+}
+
+func notifyAuthor(userID string, request ReactionRequest) {
+	var author string
+	err := db.DB.QueryRow(authorQueries[request.TargetType], request.TargetID).Scan(&author)
+	if err != nil {
+		logger.LogError("Error fetching content author", err)
+		return
+	}
+	username, ok := session.GetUsernameFromUserID(userID)
+	if !ok || username == author {
+		return
+	}
+	notification.CreateNotificationAbout(author, username, notification.TypeReaction,
+		username+" reacted "+request.Reaction+" to your "+targetLabels[request.TargetType], request.TargetType, request.TargetID)
+}
@@ -0,0 +1,188 @@
+package richtext
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// LinkRel is set on every rendered link: user content is untrusted, must not
+// pass ranking signals, and must not get a handle on the opener window.
+const LinkRel = "nofollow noopener noreferrer ugc"
+
+var orderedItem = regexp.MustCompile(`^\d{1,9}\.\s+`)
+
+// Render turns the restricted Markdown dialect used in posts and comments
+// into HTML. Only **bold**, *italic* / _italic_, `code`, [links](https://...),
+// fenced code blocks and "-" / "1." lists are understood; everything else,
+// including any HTML in the input, comes out escaped.
+func Render(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var out strings.Builder
+	var paragraph []string
+	list := ""
+
+	flushParagraph := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		out.WriteString("<p>")
+		for i, line := range paragraph {
+			if i > 0 {
+				out.WriteString("<br>")
+			}
+			out.WriteString(inline(line, true))
+		}
+		out.WriteString("</p>")
+		paragraph = nil
+	}
+	closeList := func() {
+		if list != "" {
+			out.WriteString("</" + list + ">")
+			list = ""
+		}
+	}
+	openList := func(tag string) {
+		if list != tag {
+			closeList()
+			out.WriteString("<" + tag + ">")
+			list = tag
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			end := -1
+			for j := i + 1; j < len(lines); j++ {
+				if strings.TrimSpace(lines[j]) == "```" {
+					end = j
+					break
+				}
+			}
+			if end != -1 {
+				flushParagraph()
+				closeList()
+				out.WriteString("<pre><code>")
+				out.WriteString(html.EscapeString(strings.Join(lines[i+1:end], "\n")))
+				out.WriteString("</code></pre>")
+				i = end
+				continue
+			}
+		}
+
+		switch {
+		case trimmed == "":
+			flushParagraph()
+			closeList()
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* "):
+			flushParagraph()
+			openList("ul")
+			out.WriteString("<li>" + inline(strings.TrimSpace(trimmed[2:]), true) + "</li>")
+		case orderedItem.MatchString(trimmed):
+			flushParagraph()
+			openList("ol")
+			item := orderedItem.ReplaceAllString(trimmed, "")
+			out.WriteString("<li>" + inline(item, true) + "</li>")
+		default:
+			closeList()
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flushParagraph()
+	closeList()
+	return out.String()
+}
+
+// inline renders emphasis, code and links inside one line. Links can't nest,
+// so label text is rendered with links switched off.
+func inline(text string, links bool) string {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.ContainsRune("\\`*_[]()", rune(rest[1])):
+			out.WriteString(html.EscapeString(rest[1:2]))
+			i += 2
+			continue
+
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				out.WriteString("<code>" + html.EscapeString(rest[1:1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+
+		case strings.HasPrefix(rest, "**"):
+			if end := strings.Index(rest[2:], "**"); end > 0 {
+				out.WriteString("<strong>" + inline(rest[2:2+end], links) + "</strong>")
+				i += end + 4
+				continue
+			}
+
+		case rest[0] == '*' || (rest[0] == '_' && (i == 0 || !isWordByte(text[i-1]))):
+			if end := strings.IndexByte(rest[1:], rest[0]); end > 0 && rest[1] != ' ' {
+				out.WriteString("<em>" + inline(rest[1:1+end], links) + "</em>")
+				i += end + 2
+				continue
+			}
+
+		case rest[0] == '[' && links:
+			if label, target, n, ok := link(rest); ok {
+				out.WriteString(`<a href="` + html.EscapeString(target) + `" rel="` + LinkRel + `" target="_blank">`)
+				out.WriteString(inline(label, false))
+				out.WriteString("</a>")
+				i += n
+				continue
+			}
+		}
+		out.WriteString(html.EscapeString(rest[:1]))
+		i++
+	}
+	return out.String()
+}
+
+// isWordByte keeps snake_case names and URLs from turning into italics.
+func isWordByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b >= 0x80
+}
+
+// link parses "[label](target)" at the start of text and returns the number
+// of bytes it spans. Targets other than absolute http(s) and mailto URLs are
+// refused, which rules out javascript:, data: and relative tricks.
+func link(text string) (label, target string, n int, ok bool) {
+	closeLabel := strings.Index(text, "](")
+	if closeLabel <= 1 {
+		return "", "", 0, false
+	}
+	closeTarget := strings.IndexByte(text[closeLabel+2:], ')')
+	if closeTarget <= 0 {
+		return "", "", 0, false
+	}
+	label = text[1:closeLabel]
+	target = strings.TrimSpace(text[closeLabel+2 : closeLabel+2+closeTarget])
+	if strings.ContainsAny(label, "[]") || !SafeURL(target) {
+		return "", "", 0, false
+	}
+	return label, target, closeLabel + 3 + closeTarget, true
+}
+
+func SafeURL(raw string) bool {
+	if raw == "" || strings.ContainsAny(raw, " \t\n\"'<>`\\") {
+		return false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package richtext
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var allowedTags = map[string]bool{
+	"p": true, "br": true, "strong": true, "em": true, "code": true,
+	"pre": true, "ul": true, "ol": true, "li": true, "a": true,
+}
+
+var (
+	tagPattern  = regexp.MustCompile(`<(/?)([a-zA-Z0-9]+)([^<>]*)>`)
+	linkAttrs   = regexp.MustCompile(`^ href="([^"]*)" rel="` + regexp.QuoteMeta(LinkRel) + `" target="_blank"$`)
+	voidElement = map[string]bool{"br": true}
+)
+
+// checkSafe fails the test unless every tag in out is on the allow-list,
+// only links carry attributes, every link has a safe href and the fixed rel
+// and target, tags are balanced, and nothing outside the tags could start a
+// tag or break out of an attribute.
+func checkSafe(t *testing.T, input, out string) {
+	t.Helper()
+	var open []string
+	for _, m := range tagPattern.FindAllStringSubmatch(out, -1) {
+		closing, name, attrs := m[1] == "/", m[2], m[3]
+		if !allowedTags[name] {
+			t.Fatalf("Render(%q) emitted <%s>: %s", input, name, out)
+		}
+		if closing {
+			if attrs != "" || len(open) == 0 || open[len(open)-1] != name {
+				t.Fatalf("Render(%q) has unbalanced </%s>: %s", input, name, out)
+			}
+			open = open[:len(open)-1]
+			continue
+		}
+		if name == "a" {
+			link := linkAttrs.FindStringSubmatch(attrs)
+			if link == nil {
+				t.Fatalf("Render(%q) emitted a link with attributes %q: %s", input, attrs, out)
+			}
+			if href := html.UnescapeString(link[1]); !SafeURL(href) {
+				t.Fatalf("Render(%q) emitted unsafe href %q: %s", input, href, out)
+			}
+		} else if attrs != "" {
+			t.Fatalf("Render(%q) emitted <%s> with attributes %q: %s", input, name, attrs, out)
+		}
+		if !voidElement[name] {
+			open = append(open, name)
+		}
+	}
+	if len(open) != 0 {
+		t.Fatalf("Render(%q) left %v open: %s", input, open, out)
+	}
+	if rest := tagPattern.ReplaceAllString(out, ""); strings.ContainsAny(rest, `<>"'`) {
+		t.Fatalf("Render(%q) left raw markup outside tags: %s", input, out)
+	}
+}
+
+var xssPayloads = []struct {
+	name     string
+	input    string
+	contains []string
+	excludes []string
+}{
+	{"javascript link", "[x](javascript:alert(1))", nil, []string{"<a "}},
+	{"mixed case scheme", "[x](JaVaScRiPt:alert(1))", nil, []string{"<a "}},
+	{"padded scheme", "[x](\tjavascript:alert(1))", nil, []string{"<a "}},
+	{"data link", "[x](data:text/html;base64,PHNjcmlwdD4=)", nil, []string{"<a "}},
+	{"data link with markup", "[x](data:text/html,<script>alert(1)</script>)", []string{"&lt;script&gt;"}, []string{"<a ", "<script"}},
+	{"vbscript link", "[x](vbscript:msgbox(1))", nil, []string{"<a "}},
+	{"scheme-relative link", "[x](//evil.example)", nil, []string{"<a "}},
+	{"https without host", "[x](https:javascript:alert(1))", nil, []string{"<a "}},
+	{"empty mailto", "[x](mailto:)", nil, []string{"<a "}},
+	{"quote breakout in href", `[x](https://a.example/"onmouseover="alert(1))`, nil, []string{"<a ", `"onmouseover`}},
+	{"single quote breakout", "[x](https://a.example/'onmouseover='alert(1))", nil, []string{"<a "}},
+	{"space breakout", `[x](https://a.example/ onclick=alert(1))`, nil, []string{"<a "}},
+	{"angle bracket in href", "[x](https://a.example/<script>)", nil, []string{"<a ", "<script"}},
+	{"backtick in href", "[x](https://a.example/`)", nil, []string{"<a "}},
+	{"entity in href", "[x](https://a.example/&#34;onmouseover=alert`1`)", nil, []string{"<a "}},
+	{"entity stays escaped", "[x](https://a.example/&#34;onmouseover=x)", []string{`href="https://a.example/&amp;#34;onmouseover=x"`}, nil},
+	{"nested link in label", "[[x](https://a.example)](javascript:alert(1))", nil, []string{"javascript:alert(1)\""}},
+	{"link as target", "[x]([y](https://a.example))", []string{"<p>[x](<a "}, []string{`href="[`}},
+	{"link inside label", "[a [b](https://b.example) c](https://a.example)", nil, []string{"<a href=\"https://a.example\""}},
+	{"markup in label", "[<img src=x onerror=alert(1)>](https://a.example)", []string{"&lt;img src=x onerror=alert(1)&gt;</a>"}, []string{"<img"}},
+	{"emphasis around link", "**[x](https://a.example)**", []string{"<strong><a href=\"https://a.example\""}, nil},
+	{"raw script tag", "<script>alert(1)</script>", []string{"&lt;script&gt;alert(1)&lt;/script&gt;"}, []string{"<script"}},
+	{"raw anchor", `<a href="javascript:alert(1)">x</a>`, []string{"&lt;a href=&#34;javascript:alert(1)&#34;&gt;"}, []string{"<a "}},
+	{"raw tag in code span", "`<script>alert(1)</script>`", []string{"<code>&lt;script&gt;alert(1)&lt;/script&gt;</code>"}, []string{"<script"}},
+	{"link in code span", "`[x](https://a.example)`", []string{"<code>[x](https://a.example)</code>"}, []string{"<a "}},
+	{"raw tag in fenced code", "```\n<script>alert(1)</script>\n```", []string{"<pre><code>&lt;script&gt;"}, []string{"<script"}},
+	{"unterminated fence", "```\n<script>", []string{"&lt;script&gt;"}, []string{"<script"}},
+	{"raw tag in bullet", "- <img src=x onerror=alert(1)>", []string{"<ul><li>&lt;img"}, []string{"<img"}},
+	{"raw tag in numbered item", "1. <svg onload=alert(1)>", []string{"<ol><li>&lt;svg"}, []string{"<svg"}},
+	{"code span in list", "* `<iframe>`", []string{"<li><code>&lt;iframe&gt;</code></li>"}, []string{"<iframe"}},
+	{"escaped brackets", `\[x\](javascript:alert(1))`, nil, []string{"<a "}},
+	{"unclosed emphasis", "**<b>", []string{"**&lt;b&gt;"}, []string{"<b>"}},
+	{"safe link", "[docs](https://a.example/path?q=1&r=2)", []string{`<a href="https://a.example/path?q=1&amp;r=2" rel="` + LinkRel + `" target="_blank">docs</a>`}, nil},
+	{"mailto link", "[mail](mailto:someone@a.example)", []string{`href="mailto:someone@a.example"`}, nil},
+}
+
+func TestRenderXSSPayloads(t *testing.T) {
+	for _, tt := range xssPayloads {
+		t.Run(tt.name, func(t *testing.T) {
+			out := Render(tt.input)
+			checkSafe(t, tt.input, out)
+			for _, want := range tt.contains {
+				if !strings.Contains(out, want) {
+					t.Errorf("Render(%q) = %s, want it to contain %s", tt.input, out, want)
+				}
+			}
+			for _, bad := range tt.excludes {
+				if strings.Contains(out, bad) {
+					t.Errorf("Render(%q) = %s, must not contain %s", tt.input, out, bad)
+				}
+			}
+		})
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://a.example", true},
+		{"http://a.example/x?y=1", true},
+		{"HTTPS://a.example", true},
+		{"mailto:someone@a.example", true},
+		{"", false},
+		{"/relative", false},
+		{"//a.example", false},
+		{"javascript:alert(1)", false},
+		{"data:text/html,x", false},
+		{"https://a.example/\"x", false},
+		{"https://a.example/ x", false},
+		{"mailto:", false},
+	}
+	for _, tt := range tests {
+		if got := SafeURL(tt.url); got != tt.want {
+			t.Errorf("SafeURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func FuzzRender(f *testing.F) {
+	for _, tt := range xssPayloads {
+		f.Add(tt.input)
+	}
+	f.Add("**bold** *em* _em_ `code`\n\n- a\n- b\n1. c\n```\nx\n```")
+	f.Fuzz(func(t *testing.T, input string) {
+		checkSafe(t, input, Render(input))
+	})
+}
@@ -0,0 +1,309 @@
+package tags
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"social-net/db"
+	"social-net/imaging"
+	logger "social-net/log"
+	"social-net/session"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	TargetPost      = "post"
+	TargetGroupPost = "group_post"
+
+	MaxTagLength = 50
+	TrendingSize = 10
+)
+
+var tagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/])#([\p{L}\p{N}_]+)`)
+
+var Windows = map[string]time.Duration{
+	"1h":  time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type TagItem struct {
+	Type          string `json:"type"`
+	Id            string `json:"id"`
+	GroupId       string `json:"group_id,omitempty"`
+	Author        string `json:"author"`
+	Avatar        string `json:"avatar"`
+	Title         string `json:"title"`
+	Content       string `json:"content"`
+	Image         string `json:"image"`
+	Creation_date string `json:"creation_date"`
+}
+
+type TrendingTag struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+var accessChecks = map[string]func(userID string, targetID string) bool{}
+
+// SetAccessCheck registers the visibility rule for a target type; tagged
+// content of a type without a check is never shown.
+func SetAccessCheck(targetType string, check func(userID string, targetID string) bool) {
+	accessChecks[targetType] = check
+}
+
+// Extract returns the distinct, lowercased hashtags found in text.
+func Extract(text string) []string {
+	seen := map[string]bool{}
+	var tags []string
+	for _, match := range tagPattern.FindAllStringSubmatch(text, -1) {
+		tag := strings.ToLower(match[1])
+		if len([]rune(tag)) > MaxTagLength || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Index syncs the tags of a target with its text. Tags that were already
+// present keep their original timestamp so edits don't re-bump trending.
+func Index(exec Execer, targetType, targetID, text string) error {
+	tags := Extract(text)
+	args := []interface{}{targetType, targetID}
+	query := "DELETE FROM post_tags WHERE target_type = ? AND target_id = ?"
+	if len(tags) > 0 {
+		query += " AND tag NOT IN (?" + strings.Repeat(", ?", len(tags)-1) + ")"
+		for _, tag := range tags {
+			args = append(args, tag)
+		}
+	}
+	if _, err := exec.Exec(query, args...); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, tag := range tags {
+		tagID, err := uuid.NewV7()
+		if err != nil {
+			return err
+		}
+		_, err = exec.Exec("INSERT OR IGNORE INTO post_tags (id, tag, target_type, target_id, created_at) VALUES (?, ?, ?, ?, ?)",
+			tagID.String(), tag, targetType, targetID, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Remove(exec Execer, targetType, targetID string) error {
+	_, err := exec.Exec("DELETE FROM post_tags WHERE target_type = ? AND target_id = ?", targetType, targetID)
+	return err
+}
+
+// IndexPost is run when a post is published.
+func IndexPost(postID string) {
+	var content string
+	if err := db.DB.QueryRow("SELECT content FROM posts WHERE id = ?", postID).Scan(&content); err != nil {
+		logger.LogError("Error fetching post for tags", err)
+		return
+	}
+	if err := Index(db.DB, TargetPost, postID, content); err != nil {
+		logger.LogError("Error indexing post tags", err)
+	}
+}
+
+func GetTagFeed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	tag := strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/tags/"), "#"))
+	if tag == "" || strings.Contains(tag, "/") {
+		http.Error(w, "Missing tag", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT t.target_type, p.id, '', u.username, COALESCE(u.avatar, ''), p.title, p.content, COALESCE(p.image, ''), p.creation_date
+		FROM post_tags t
+		JOIN posts p ON t.target_type = 'post' AND p.id = t.target_id
+		JOIN users u ON p.user_id = u.id
+		WHERE t.tag = ? AND p.state = 'published'
+		UNION ALL
+		SELECT t.target_type, gp.id, gp.group_id, u.username, COALESCE(u.avatar, ''), gp.title, gp.content, COALESCE(gp.image, ''), gp.creation_date
+		FROM post_tags t
+		JOIN group_posts gp ON t.target_type = 'group_post' AND gp.id = t.target_id
+		JOIN users u ON gp.user_id = u.id
+		WHERE t.tag = ?
+		ORDER BY 9 DESC
+	`, tag, tag)
+	if err != nil {
+		logger.LogError("Error fetching tag feed", err)
+		http.Error(w, "Error fetching tag feed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := []TagItem{}
+	for rows.Next() {
+		var item TagItem
+		err := rows.Scan(&item.Type, &item.Id, &item.GroupId, &item.Author, &item.Avatar, &item.Title, &item.Content, &item.Image, &item.Creation_date)
+		if err != nil {
+			logger.LogError("Error scanning tag feed", err)
+			http.Error(w, "Error scanning tag feed", http.StatusInternalServerError)
+			return
+		}
+		check, ok := accessChecks[item.Type]
+		if !ok || !check(userID, item.Id) {
+			continue
+		}
+		if item.Image != "" {
+			item.Image = imaging.URL(item.Image, imaging.SizeMedium)
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tag":   tag,
+		"items": items,
+	})
+}
+
+var (
+	trendingMu        sync.RWMutex
+	trending          = map[string][]TrendingTag{}
+	trendingRefreshed time.Time
+)
+
+// StartTrendingJob recomputes the trending tags for every window on a fixed
+// interval so requests only read the last snapshot.
+func StartTrendingJob(interval time.Duration) {
+	refreshTrending()
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			refreshTrending()
+		}
+	}()
+}
+
+// refreshTrending only counts public posts; group posts and restricted posts
+// are left out so a tag name can't leak content outside its audience.
+func refreshTrending() {
+	now := time.Now().UTC()
+	snapshot := map[string][]TrendingTag{}
+	for name, window := range Windows {
+		rows, err := db.DB.Query(`
+			SELECT t.tag, COUNT(*) AS uses
+			FROM post_tags t
+			JOIN posts p ON t.target_type = 'post' AND p.id = t.target_id
+			WHERE p.status = 'public' AND p.state = 'published' AND t.created_at >= ?
+			GROUP BY t.tag
+			ORDER BY uses DESC, t.tag ASC
+			LIMIT ?
+		`, now.Add(-window), TrendingSize)
+		if err != nil {
+			logger.LogError("Error computing trending tags", err)
+			return
+		}
+		list := []TrendingTag{}
+		for rows.Next() {
+			var trend TrendingTag
+			if err := rows.Scan(&trend.Tag, &trend.Count); err != nil {
+				logger.LogError("Error scanning trending tag", err)
+				continue
+			}
+			list = append(list, trend)
+		}
+		rows.Close()
+		snapshot[name] = list
+	}
+
+	trendingMu.Lock()
+	trending = snapshot
+	trendingRefreshed = now
+	trendingMu.Unlock()
+}
+
+func GetTrending(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	if userID, ok := session.GetUserIDFromToken(token.Value); !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = "24h"
+	}
+	if _, ok := Windows[window]; !ok {
+		names := make([]string, 0, len(Windows))
+		for name := range Windows {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		http.Error(w, "Invalid window, expected one of "+strings.Join(names, ", "), http.StatusBadRequest)
+		return
+	}
+
+	trendingMu.RLock()
+	list := trending[window]
+	refreshed := trendingRefreshed
+	trendingMu.RUnlock()
+	if list == nil {
+		list = []TrendingTag{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window":       window,
+		"tags":         list,
+		"refreshed_at": refreshed,
+	})
+}
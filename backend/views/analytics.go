@@ -0,0 +1,323 @@
+package views
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/session"
+)
+
+const (
+	DefaultDays = 30
+	MaxDays     = 90
+	topPosts    = 10
+)
+
+type Day struct {
+	Date          string `json:"date"`
+	Views         int    `json:"views"`
+	UniqueViewers int    `json:"unique_viewers"`
+	Reactions     int    `json:"reactions"`
+	Comments      int    `json:"comments"`
+	NewFollowers  int    `json:"new_followers,omitempty"`
+}
+
+type Totals struct {
+	Views         int `json:"views"`
+	UniqueViewers int `json:"unique_viewers"`
+	Reactions     int `json:"reactions"`
+	Comments      int `json:"comments"`
+	NewFollowers  int `json:"new_followers"`
+	Followers     int `json:"followers"`
+}
+
+type PostStats struct {
+	PostID        string `json:"post_id"`
+	Title         string `json:"title"`
+	Views         int    `json:"views"`
+	UniqueViewers int    `json:"unique_viewers"`
+	Reactions     int    `json:"reactions"`
+	Comments      int    `json:"comments"`
+}
+
+type ProfileAnalytics struct {
+	Days   int         `json:"days"`
+	Totals Totals      `json:"totals"`
+	Daily  []Day       `json:"daily"`
+	Posts  []PostStats `json:"top_posts"`
+}
+
+type PostAnalytics struct {
+	PostStats
+	Daily []Day `json:"daily"`
+}
+
+// series returns one zeroed Day per date of the window, oldest first, and an
+// index to fill them by date.
+func series(days int) ([]Day, map[string]int, string) {
+	daily := make([]Day, days)
+	index := make(map[string]int, days)
+	start := time.Now().UTC().AddDate(0, 0, -(days - 1))
+	for i := range daily {
+		date := start.AddDate(0, 0, i).Format("2006-01-02")
+		daily[i].Date = date
+		index[date] = i
+	}
+	return daily, index, daily[0].Date
+}
+
+// fill runs a query returning (date, count...) rows and hands each row to set.
+func fill(daily []Day, index map[string]int, set func(*Day, []int), query string, args ...interface{}) error {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var date string
+		counts := make([]int, len(columns)-1)
+		dest := []interface{}{&date}
+		for i := range counts {
+			dest = append(dest, &counts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if i, ok := index[date]; ok {
+			set(&daily[i], counts)
+		}
+	}
+	return rows.Err()
+}
+
+func authenticate(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return "", 0, false
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", 0, false
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return "", 0, false
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return "", 0, false
+	}
+
+	days := DefaultDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 || days > MaxDays {
+			http.Error(w, "Invalid days parameter", http.StatusBadRequest)
+			return "", 0, false
+		}
+	}
+
+	// Counts still in memory would otherwise be missing from the report.
+	if err := Flush(); err != nil {
+		logger.LogError("Error flushing post views", err)
+	}
+	return userID, days, true
+}
+
+// GetProfileAnalytics reports views, reactions, comments and follower growth
+// across all of the caller's posts.
+func GetProfileAnalytics(w http.ResponseWriter, r *http.Request) {
+	userID, days, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	daily, index, since := series(days)
+	var totals Totals
+	steps := []struct {
+		set   func(*Day, []int)
+		query string
+		args  []interface{}
+	}{
+		{func(d *Day, c []int) { d.Views, d.UniqueViewers = c[0], c[1] }, `
+			SELECT v.day, SUM(v.impressions), COUNT(DISTINCT v.viewer_id)
+			FROM post_views v JOIN posts p ON p.id = v.post_id
+			WHERE p.user_id = ? AND v.day >= ?
+			GROUP BY v.day`, []interface{}{userID, since}},
+		{func(d *Day, c []int) { d.Reactions = c[0] }, `
+			SELECT DATE(r.created_at), COUNT(*)
+			FROM reactions r JOIN posts p ON r.target_type = 'post' AND r.target_id = p.id
+			WHERE p.user_id = ? AND r.user_id != ? AND DATE(r.created_at) >= ?
+			GROUP BY 1`, []interface{}{userID, userID, since}},
+		{func(d *Day, c []int) { d.Comments = c[0] }, `
+			SELECT DATE(c.creation_date), COUNT(*)
+			FROM comments c JOIN posts p ON c.target_type = 'post' AND c.target_id = p.id
+			WHERE p.user_id = ? AND c.author != (SELECT username FROM users WHERE id = ?) AND DATE(c.creation_date) >= ?
+			GROUP BY 1`, []interface{}{userID, userID, since}},
+		{func(d *Day, c []int) { d.NewFollowers = c[0] }, `
+			SELECT DATE(accepted_at), COUNT(*)
+			FROM Followers
+			WHERE followed_id = ? AND status = 'accepted' AND accepted_at IS NOT NULL AND DATE(accepted_at) >= ?
+			GROUP BY 1`, []interface{}{userID, since}},
+	}
+	for _, step := range steps {
+		if err := fill(daily, index, step.set, step.query, step.args...); err != nil {
+			logger.LogError("Error computing analytics", err)
+			http.Error(w, "Error computing analytics", http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, day := range daily {
+		totals.Views += day.Views
+		totals.Reactions += day.Reactions
+		totals.Comments += day.Comments
+		totals.NewFollowers += day.NewFollowers
+	}
+
+	err := db.DB.QueryRow(`
+		SELECT COUNT(DISTINCT v.viewer_id)
+		FROM post_views v JOIN posts p ON p.id = v.post_id
+		WHERE p.user_id = ? AND v.day >= ?
+	`, userID, since).Scan(&totals.UniqueViewers)
+	if err == nil {
+		err = db.DB.QueryRow("SELECT COUNT(*) FROM Followers WHERE followed_id = ? AND status = 'accepted'", userID).Scan(&totals.Followers)
+	}
+	if err != nil {
+		logger.LogError("Error computing analytics", err)
+		http.Error(w, "Error computing analytics", http.StatusInternalServerError)
+		return
+	}
+
+	posts, err := topPostStats(userID, since)
+	if err != nil {
+		logger.LogError("Error computing post analytics", err)
+		http.Error(w, "Error computing analytics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProfileAnalytics{Days: days, Totals: totals, Daily: daily, Posts: posts})
+}
+
+func topPostStats(userID, since string) ([]PostStats, error) {
+	rows, err := db.DB.Query(`
+		SELECT p.id, p.title, COALESCE(SUM(v.impressions), 0), COUNT(DISTINCT v.viewer_id)
+		FROM posts p
+		LEFT JOIN post_views v ON v.post_id = p.id AND v.day >= ?
+		WHERE p.user_id = ? AND p.state = 'published'
+		GROUP BY p.id
+		ORDER BY 3 DESC, p.creation_date DESC
+		LIMIT ?
+	`, since, userID, topPosts)
+	if err != nil {
+		return nil, err
+	}
+	posts := []PostStats{}
+	for rows.Next() {
+		var stats PostStats
+		if err := rows.Scan(&stats.PostID, &stats.Title, &stats.Views, &stats.UniqueViewers); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		posts = append(posts, stats)
+	}
+	rows.Close()
+	for i := range posts {
+		if err := engagement(&posts[i], userID); err != nil {
+			return nil, err
+		}
+	}
+	return posts, nil
+}
+
+// engagement counts the reactions and comments on a post, leaving out the
+// author's own like the profile totals do.
+func engagement(stats *PostStats, authorID string) error {
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM reactions WHERE target_type = 'post' AND target_id = ? AND user_id != ?",
+		stats.PostID, authorID).Scan(&stats.Reactions)
+	if err != nil {
+		return err
+	}
+	return db.DB.QueryRow(`
+		SELECT COUNT(*) FROM comments
+		WHERE target_type = 'post' AND target_id = ? AND author != (SELECT username FROM users WHERE id = ?)
+	`, stats.PostID, authorID).Scan(&stats.Comments)
+}
+
+// GetPostAnalytics reports all-time totals and a daily breakdown for one of
+// the caller's posts.
+func GetPostAnalytics(w http.ResponseWriter, r *http.Request) {
+	userID, days, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	postID := r.URL.Query().Get("post_id")
+	if postID == "" {
+		http.Error(w, "Missing post_id parameter", http.StatusBadRequest)
+		return
+	}
+	var result PostAnalytics
+	var ownerID string
+	err := db.DB.QueryRow("SELECT id, title, user_id FROM posts WHERE id = ?", postID).Scan(&result.PostID, &result.Title, &ownerID)
+	if err == sql.ErrNoRows || (err == nil && ownerID != userID) {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		err = db.DB.QueryRow("SELECT COALESCE(SUM(impressions), 0), COUNT(DISTINCT viewer_id) FROM post_views WHERE post_id = ?",
+			postID).Scan(&result.Views, &result.UniqueViewers)
+	}
+	if err == nil {
+		err = engagement(&result.PostStats, userID)
+	}
+	if err != nil {
+		logger.LogError("Error computing post analytics", err)
+		http.Error(w, "Error computing analytics", http.StatusInternalServerError)
+		return
+	}
+
+	daily, index, since := series(days)
+	err = fill(daily, index, func(d *Day, c []int) { d.Views, d.UniqueViewers = c[0], c[1] }, `
+		SELECT day, SUM(impressions), COUNT(DISTINCT viewer_id)
+		FROM post_views WHERE post_id = ? AND day >= ?
+		GROUP BY day`, postID, since)
+	if err == nil {
+		err = fill(daily, index, func(d *Day, c []int) { d.Reactions = c[0] }, `
+			SELECT DATE(created_at), COUNT(*) FROM reactions
+			WHERE target_type = 'post' AND target_id = ? AND user_id != ? AND DATE(created_at) >= ?
+			GROUP BY 1`, postID, userID, since)
+	}
+	if err == nil {
+		err = fill(daily, index, func(d *Day, c []int) { d.Comments = c[0] }, `
+			SELECT DATE(creation_date), COUNT(*) FROM comments
+			WHERE target_type = 'post' AND target_id = ? AND author != (SELECT username FROM users WHERE id = ?) AND DATE(creation_date) >= ?
+			GROUP BY 1`, postID, userID, since)
+	}
+	if err != nil {
+		logger.LogError("Error computing post analytics", err)
+		http.Error(w, "Error computing analytics", http.StatusInternalServerError)
+		return
+	}
+	result.Daily = daily
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
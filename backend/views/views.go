@@ -0,0 +1,113 @@
+package views
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"social-net/db"
+	logger "social-net/log"
+)
+
+type viewKey struct {
+	postID   string
+	viewerID string
+	day      string
+}
+
+// Views are counted in memory and written in batches, so rendering a feed
+// costs a map update per post instead of a write.
+var (
+	mu      sync.Mutex
+	pending = map[viewKey]int{}
+)
+
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// Record counts one impression of each post for viewerID. Authors looking
+// at their own posts are not counted.
+func Record(viewerID string, postIDs map[string]string) {
+	day := today()
+	mu.Lock()
+	defer mu.Unlock()
+	for postID, authorID := range postIDs {
+		if authorID == viewerID {
+			continue
+		}
+		pending[viewKey{postID: postID, viewerID: viewerID, day: day}]++
+	}
+}
+
+// Flush writes the buffered impressions. A viewer has one row per post per
+// day, which is what makes unique viewer counts cheap.
+func Flush() error {
+	mu.Lock()
+	batch := pending
+	pending = map[viewKey]int{}
+	mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		requeue(batch)
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`
+		INSERT INTO post_views (post_id, viewer_id, day, impressions) VALUES (?, ?, ?, ?)
+		ON CONFLICT (post_id, viewer_id, day) DO UPDATE SET impressions = impressions + excluded.impressions
+	`)
+	if err != nil {
+		requeue(batch)
+		return err
+	}
+	defer stmt.Close()
+	for key, count := range batch {
+		if _, err := stmt.Exec(key.postID, key.viewerID, key.day, count); err != nil {
+			requeue(batch)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		requeue(batch)
+		return err
+	}
+	return nil
+}
+
+func requeue(batch map[viewKey]int) {
+	mu.Lock()
+	defer mu.Unlock()
+	for key, count := range batch {
+		pending[key] += count
+	}
+}
+
+func StartFlusher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := Flush(); err != nil {
+				logger.LogError("Error flushing post views", err)
+			}
+		}
+	}()
+}
+
+// DeletePost drops the counts of a deleted post, including unflushed ones.
+func DeletePost(tx *sql.Tx, postID string) error {
+	mu.Lock()
+	for key := range pending {
+		if key.postID == postID {
+			delete(pending, key)
+		}
+	}
+	mu.Unlock()
+	_, err := tx.Exec("DELETE FROM post_views WHERE post_id = ?", postID)
+	return err
+}
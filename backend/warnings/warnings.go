@@ -0,0 +1,102 @@
+package warnings
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"social-net/db"
+	logger "social-net/log"
+	"social-net/session"
+)
+
+const (
+	MaxWarningLength = 200
+
+	// What a viewer wants done with posts flagged as sensitive media.
+	MediaCollapse = "collapse"
+	MediaShow     = "show"
+	MediaHide     = "hide"
+)
+
+// FromForm reads the content_warning and sensitive fields of a post or
+// comment form.
+func FromForm(r *http.Request) (string, bool, error) {
+	warning := strings.TrimSpace(r.FormValue("content_warning"))
+	if len([]rune(warning)) > MaxWarningLength {
+		return "", false, fmt.Errorf("Content warning must not exceed %d characters", MaxWarningLength)
+	}
+	return warning, r.FormValue("sensitive") == "true", nil
+}
+
+// Preference returns how userID wants sensitive media handled.
+func Preference(userID string) string {
+	var preference string
+	err := db.DB.QueryRow("SELECT sensitive_media FROM users WHERE id = ?", userID).Scan(&preference)
+	if err != nil {
+		return MediaCollapse
+	}
+	return preference
+}
+
+// Apply decides how a flagged item is shown. A written content warning always
+// collapses the item; the viewer's preference only covers sensitive media.
+// When hideMedia is set the caller leaves images and attachments out.
+func Apply(preference, warning string, sensitive bool) (collapsed, hideMedia bool) {
+	collapsed = warning != "" || (sensitive && preference == MediaCollapse)
+	hideMedia = sensitive && preference == MediaHide
+	return collapsed, hideMedia
+}
+
+// SensitiveMediaPreference reads (GET) or sets (POST {"sensitive_media": ...})
+// the caller's preference.
+func SensitiveMediaPreference(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "http://social-net.duckdns.org")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	token, err := r.Cookie("token")
+	if err != nil {
+		http.Error(w, "Unauthorized: Missing token", http.StatusUnauthorized)
+		return
+	}
+	userID, ok := session.GetUserIDFromToken(token.Value)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var request struct {
+			SensitiveMedia string `json:"sensitive_media"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if request.SensitiveMedia != MediaCollapse && request.SensitiveMedia != MediaShow && request.SensitiveMedia != MediaHide {
+			http.Error(w, "Invalid sensitive_media value", http.StatusBadRequest)
+			return
+		}
+		if _, err := db.DB.Exec("UPDATE users SET sensitive_media = ? WHERE id = ?", request.SensitiveMedia, userID); err != nil {
+			logger.LogError("Failed to update sensitive media preference", err)
+			http.Error(w, "Failed to update preference", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"sensitive_media": Preference(userID)})
+}